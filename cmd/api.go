@@ -0,0 +1,270 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+)
+
+// otgApi wraps gosnappi.Api of an OTG API endpoint, and keeps track of what was started on it
+type otgApi struct {
+	gosnappi.Api
+	location         string              // URL of the OTG API endpoint
+	connect          func() gosnappi.Api // Creates another handle to the endpoint
+	ctx              context.Context     // Context of the run, with its deadline
+	mu               sync.Mutex
+	pollApis         []gosnappi.Api        // More handles to the endpoint, for metrics requests polled concurrently
+	abandoned        map[gosnappi.Api]bool // Handles with a call that did not complete in time still running on them
+	protocolsStarted bool
+	trafficStarted   bool
+	retried          int               // Number of API calls that had to be retried
+	baseline         map[string]uint64 // Port and flow counters at the time statistics were cleared
+	iteration        int               // Number of the iteration running, with --iterations
+}
+
+var otgEndpoints []*otgApi // All OTG API endpoints in use
+var otgEndpointsMu sync.Mutex
+var otgExitOnce sync.Once
+
+// Create a new API handle for an OTG API endpoint at location
+func newOtgApi(location string) gosnappi.Api {
+	a := &otgApi{location: location, connect: apiConnector(location), ctx: otgRunCtx, abandoned: map[gosnappi.Api]bool{}}
+	a.Api = a.connect()
+	otgEndpointsMu.Lock()
+	otgEndpoints = append(otgEndpoints, a)
+	otgEndpointsMu.Unlock()
+	return a
+}
+
+// Function creating gosnappi handles for the endpoint at location, all with the same transport settings.
+// A gosnappi handle is not safe for concurrent calls, as it sets up its connection on the first call, and keeps
+// track of the last one it dialed. Calls made in parallel need a handle each
+func apiConnector(location string) func() gosnappi.Api {
+	switch otgTransport {
+	case "grpc":
+		conn := grpcApiConnection(location) // shared by all the handles, gRPC connections are safe for concurrent calls
+		return func() gosnappi.Api {
+			api := gosnappi.NewApi()
+			t := api.NewGrpcTransport().SetLocation(location)
+			if otgApiTimeout > 0 {
+				t.SetRequestTimeout(otgApiTimeout)
+			}
+			if conn != nil {
+				t.SetClientConnection(conn)
+			}
+			return api
+		}
+	default:
+		httpLocation, err := httpApiLocation(location)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return func() gosnappi.Api {
+			api := gosnappi.NewApi()
+			api.NewHttpTransport().SetLocation(httpLocation).SetVerify(!otgIgnoreX509)
+			return api
+		}
+	}
+}
+
+// otgPollApi is another handle to an endpoint, to poll metrics concurrently with the main handle of the endpoint
+type otgPollApi struct {
+	*otgApi
+	handle gosnappi.Api
+}
+
+// GetMetrics is retried, and has the baseline subtracted, same as on the main handle
+func (p otgPollApi) GetMetrics(req gosnappi.MetricsRequest) (gosnappi.MetricsResponse, error) {
+	return p.getMetrics(p.handle, req)
+}
+
+// Handle of the endpoint for the i-th of metrics requests polled concurrently. Endpoints of a run are all otgApi
+func pollApi(api gosnappi.Api, i int) gosnappi.Api {
+	if a, ok := api.(*otgApi); ok {
+		return a.pollApi(i)
+	}
+	return api
+}
+
+// Handle for the i-th of metrics requests polled concurrently. The first request uses the main handle, and every
+// other one gets a handle of its own, created on first use and kept for the next polls
+func (a *otgApi) pollApi(i int) gosnappi.Api {
+	if i == 0 {
+		return a
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for len(a.pollApis) < i {
+		a.pollApis = append(a.pollApis, a.connect())
+	}
+	return otgPollApi{a, a.pollApis[i-1]}
+}
+
+// SetControlState keeps track of protocols and traffic started on the endpoint, for teardown.
+// It is retried according to --control-retries and --control-retry-backoff
+func (a *otgApi) SetControlState(cs gosnappi.ControlState) (gosnappi.Warning, error) {
+	return a.setControlState(a.ctx, a.Api, cs)
+}
+
+func (a *otgApi) setControlState(ctx context.Context, handle gosnappi.Api, cs gosnappi.ControlState) (gosnappi.Warning, error) {
+	r, err := a.retry(ctx, otgControlRetry, "SetControlState", handle, func(h gosnappi.Api) (interface{}, error) {
+		return h.SetControlState(cs)
+	})
+	res, _ := r.(gosnappi.Warning)
+	if err != nil {
+		return res, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cs.HasProtocol() && cs.Protocol().HasAll() {
+		a.protocolsStarted = cs.Protocol().All().State() == gosnappi.StateProtocolAllState.START
+	}
+	if cs.HasTraffic() && cs.Traffic().HasFlowTransmit() {
+		ft := cs.Traffic().FlowTransmit()
+		switch ft.State() {
+		case gosnappi.StateTrafficFlowTransmitState.START, gosnappi.StateTrafficFlowTransmitState.RESUME:
+			a.trafficStarted = true
+		case gosnappi.StateTrafficFlowTransmitState.STOP:
+			if len(ft.FlowNames()) == 0 {
+				a.trafficStarted = false
+			}
+		}
+	}
+	return res, err
+}
+
+// Stop traffic and protocols started on the endpoint. Errors are reported, but do not stop the teardown.
+// It does not depend on the deadline of the run, as it may be the reason for the teardown, and it uses a handle of its
+// own, as the run may be in the middle of a call on the main one
+func (a *otgApi) teardown() {
+	ctx, cancel := context.WithTimeout(context.Background(), TEARDOWN_TIMEOUT)
+	defer cancel()
+	handle := a.connect()
+
+	a.mu.Lock()
+	trafficStarted, protocolsStarted := a.trafficStarted, a.protocolsStarted
+	a.mu.Unlock()
+
+	if trafficStarted {
+		log.Infof("Stopping traffic on %s...", a.location)
+		ts := gosnappi.NewControlState()
+		ts.Traffic().FlowTransmit().SetState(gosnappi.StateTrafficFlowTransmitState.STOP)
+		if _, err := a.setControlState(ctx, handle, ts); err != nil {
+			log.Errorf("Failed to stop traffic on %s: %v", a.location, err)
+		} else {
+			printEvent(a, newEvent(EVENT_TRAFFIC_STOPPED))
+		}
+	}
+	if protocolsStarted && protoMode == "auto" {
+		log.Infof("Stopping protocols on %s...", a.location)
+		ps := gosnappi.NewControlState()
+		ps.Protocol().All().SetState(gosnappi.StateProtocolAllState.STOP)
+		if _, err := a.setControlState(ctx, handle, ps); err != nil {
+			log.Errorf("Failed to stop protocols on %s: %v", a.location, err)
+		}
+	}
+}
+
+// Stop traffic and protocols on all the endpoints in parallel, and exit.
+// Used in place of os.Exit, as well as an exit function of the logger, so that log.Fatal on any
+// of the endpoints does not leave traffic running on the others
+func otgExit(code int) {
+	otgExitOnce.Do(func() {
+		otgEndpointsMu.Lock()
+		endpoints := otgEndpoints
+		otgEndpointsMu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, a := range endpoints {
+			wg.Add(1)
+			go func(a *otgApi) {
+				defer wg.Done()
+				a.teardown()
+			}(a)
+		}
+		wg.Wait()
+		printRunSummary()
+		stopRunDeadline()
+		os.Exit(code)
+	})
+}
+
+// Report the summary of the run for every endpoint: how many API calls had to be retried
+func printRunSummary() {
+	otgEndpointsMu.Lock()
+	endpoints := otgEndpoints
+	otgEndpointsMu.Unlock()
+
+	for _, a := range endpoints {
+		n := a.retriedCalls()
+		if n > 0 {
+			log.Warnf("Run summary for %s: %d API calls retried", a.location, n)
+		} else {
+			log.Infof("Run summary for %s: no API calls retried", a.location)
+		}
+		e := newEvent(EVENT_RUN_SUMMARY)
+		e.RetriedCalls = &n
+		printEvent(a, e)
+	}
+}
+
+// Label to tell metrics and events of the endpoint apart, when running against multiple endpoints
+func endpointLabel(api gosnappi.Api) string {
+	a, ok := api.(*otgApi)
+	if !ok {
+		return ""
+	}
+	otgEndpointsMu.Lock()
+	n := len(otgEndpoints)
+	otgEndpointsMu.Unlock()
+	if n < 2 {
+		return ""
+	}
+	return a.location
+}
+
+// Short form of an endpoint label, to use as a prefix for metric names
+func endpointPrefix(label string) string {
+	u, err := url.Parse(label)
+	if err != nil || u.Host == "" {
+		return label
+	}
+	return u.Host
+}
+
+// Run the same stage of the test on all the endpoints in parallel, and wait for all of them to complete it
+func runParallel(apis []gosnappi.Api, configs []gosnappi.Config, stage func(gosnappi.Api, gosnappi.Config) (gosnappi.Api, gosnappi.Config)) {
+	var wg sync.WaitGroup
+	for i := range apis {
+		wg.Add(1)
+		go func(api gosnappi.Api, config gosnappi.Config) {
+			defer wg.Done()
+			stage(api, config)
+		}(apis[i], configs[i])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,263 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+	"github.com/open-traffic-generator/snappi/gosnappi/otg"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var otgStopOn []string                  // Conditions to stop the run on, as provided via --stop-on
+var otgStopConditions []metricCondition // Parsed --stop-on conditions
+
+// metricCondition compares a field of metrics items with a value, like "flow:*.loss_pct>5". The same syntax is used
+// to stop a run while traffic is running, and to check results at the end of it
+type metricCondition struct {
+	text    string // Condition as it was written
+	kind    string // Metrics type: "port", "flow" or "bgp4"
+	pattern string // Glob pattern for names of ports, flows or peers
+	field   string // Field of a metrics item, like "frames_rx" or "session_state"
+	op      string // Comparison operator
+	value   string // Value to compare with
+	number  float64
+	numeric bool // Field and value are compared as numbers, otherwise as strings
+}
+
+// Metrics choice in MetricsResponse and the message of its items, for every metrics type
+var conditionKinds = map[string]struct {
+	choice string
+	item   protoreflect.MessageDescriptor
+}{
+	"port": {"port_metrics", (&otg.PortMetric{}).ProtoReflect().Descriptor()},
+	"flow": {"flow_metrics", (&otg.FlowMetric{}).ProtoReflect().Descriptor()},
+	"bgp4": {"bgpv4_metrics", (&otg.Bgpv4Metric{}).ProtoReflect().Descriptor()},
+}
+
+// Fields calculated from other fields of a metrics item, by metrics type
+var derivedFields = map[string]map[string]func(mr gosnappi.MetricsResponse, i int) float64{
+	"flow": {
+		"loss_pct": func(mr gosnappi.MetricsResponse, i int) float64 { return flowLossPct(mr.FlowMetrics().Items()[i]) },
+	},
+}
+
+var conditionRegexp = regexp.MustCompile(`^(\w+):(.+)\.(\w+)\s*(>=|<=|==|!=|>|<)\s*(.+)$`)
+
+// Parse a condition like "flow:*.loss_pct>5" or "bgp4:*.session_state!=up"
+func parseCondition(s string) (metricCondition, error) {
+	m := conditionRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return metricCondition{}, fmt.Errorf("has to be like \"flow:*.loss_pct>5\"")
+	}
+	c := metricCondition{text: s, kind: m[1], pattern: m[2], field: m[3], op: m[4], value: strings.TrimSpace(m[5])}
+	k, ok := conditionKinds[c.kind]
+	if !ok {
+		return c, fmt.Errorf("unsupported metrics type %s", c.kind)
+	}
+	if _, err := path.Match(c.pattern, ""); err != nil {
+		return c, err
+	}
+	if _, ok := derivedFields[c.kind][c.field]; ok {
+		c.numeric = true
+	} else if fd := k.item.Fields().ByName(protoreflect.Name(c.field)); fd == nil || fd.IsList() || fd.Message() != nil || c.field == "name" {
+		return c, fmt.Errorf("%s metrics have no %s field to compare", c.kind, c.field)
+	} else {
+		c.numeric = fd.Kind() != protoreflect.EnumKind && fd.Kind() != protoreflect.StringKind && fd.Kind() != protoreflect.BoolKind
+	}
+	if c.numeric {
+		v, err := strconv.ParseFloat(c.value, 64)
+		if err != nil {
+			return c, fmt.Errorf("%s is not a number", c.value)
+		}
+		c.number = v
+	} else if c.op != "==" && c.op != "!=" {
+		return c, fmt.Errorf("%s can only be compared with == or !=", c.field)
+	}
+	return c, nil
+}
+
+// Parse a list of conditions, exiting on the first incorrect one
+func parseConditions(flag string, list []string) []metricCondition {
+	conditions := []metricCondition{}
+	for _, s := range list {
+		c, err := parseCondition(s)
+		if err != nil {
+			log.Fatalf("Incorrect --%s %s: %s", flag, s, err)
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions
+}
+
+// Metrics request for all the items and fields the condition can be evaluated on
+func (c metricCondition) request() gosnappi.MetricsRequest {
+	return newMetricsTypeRequest(c.kind)
+}
+
+// Evaluate the condition on every item of matching metrics type and name in the MetricsResponse. Returns a
+// description of the first item the condition holds for, like "flow f1 loss_pct is 7.5"
+func (c metricCondition) holds(mr gosnappi.MetricsResponse) (string, bool) {
+	for _, r := range c.evaluate(mr) {
+		if r.ok {
+			return r.reason, true
+		}
+	}
+	return "", false
+}
+
+// conditionResult is the outcome of a condition for one metrics item
+type conditionResult struct {
+	reason string // Metrics item and its value, like "flow f1 loss_pct is 7.5"
+	ok     bool   // The condition holds for the item
+}
+
+// Evaluate the condition on every item of matching metrics type and name in the MetricsResponse that reports the field
+func (c metricCondition) evaluate(mr gosnappi.MetricsResponse) []conditionResult {
+	results := []conditionResult{}
+	if mr == nil || string(mr.Choice()) != conditionKinds[c.kind].choice {
+		return results
+	}
+	msg, err := mr.Marshal().ToProto()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pm := msg.ProtoReflect()
+	list := pm.Get(pm.Descriptor().Fields().ByName(protoreflect.Name(conditionKinds[c.kind].choice))).List()
+	for i := 0; i < list.Len(); i++ {
+		item := list.Get(i).Message()
+		name := item.Get(item.Descriptor().Fields().ByName("name")).String()
+		if ok, _ := path.Match(c.pattern, name); !ok {
+			continue
+		}
+		var actual string
+		var ok bool
+		if derive, derived := derivedFields[c.kind][c.field]; derived {
+			v := derive(mr, i)
+			actual, ok = strconv.FormatFloat(v, 'f', -1, 32), compareNumbers(v, c.op, c.number)
+		} else {
+			fd := item.Descriptor().Fields().ByName(protoreflect.Name(c.field))
+			if !item.Has(fd) && fd.HasPresence() {
+				continue // the field was not reported
+			}
+			v := item.Get(fd)
+			switch fd.Kind() {
+			case protoreflect.EnumKind:
+				actual = string(fd.Enum().Values().ByNumber(v.Enum()).Name())
+				ok = compareStrings(actual, c.op, c.value)
+			case protoreflect.StringKind, protoreflect.BoolKind:
+				actual = v.String()
+				ok = compareStrings(actual, c.op, c.value)
+			case protoreflect.FloatKind:
+				actual, ok = strconv.FormatFloat(v.Float(), 'f', -1, 32), compareNumbers(v.Float(), c.op, c.number)
+			case protoreflect.DoubleKind:
+				actual, ok = strconv.FormatFloat(v.Float(), 'f', -1, 64), compareNumbers(v.Float(), c.op, c.number)
+			case protoreflect.Uint32Kind, protoreflect.Uint64Kind:
+				actual, ok = strconv.FormatUint(v.Uint(), 10), compareNumbers(float64(v.Uint()), c.op, c.number)
+			default:
+				actual, ok = strconv.FormatInt(v.Int(), 10), compareNumbers(float64(v.Int()), c.op, c.number)
+			}
+		}
+		results = append(results, conditionResult{fmt.Sprintf("%s %s %s is %s", c.kind, name, c.field, actual), ok})
+	}
+	return results
+}
+
+func compareNumbers(a float64, op string, b float64) bool {
+	switch op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
+
+func compareStrings(a string, op string, b string) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
+
+// Loss of a flow in percent, as reported by the traffic generator, or calculated from transmitted and received frames.
+// Zero if either of the frame counters isn't reported
+func flowLossPct(f gosnappi.FlowMetric) float64 {
+	if f.HasLoss() {
+		return float64(f.Loss())
+	}
+	if !f.HasFramesTx() || !f.HasFramesRx() {
+		return 0
+	}
+	if f.FramesTx() > 0 && f.FramesTx() > f.FramesRx() {
+		return float64(f.FramesTx()-f.FramesRx()) / float64(f.FramesTx()) * 100
+	}
+	return 0
+}
+
+// Add polls for metrics --stop-on conditions are evaluated on, unless all items and fields of the metrics are already polled
+func addStopConditionPolls(polls []*metricsPoll) []*metricsPoll {
+	added := map[string]bool{}
+	for _, c := range otgStopConditions {
+		if added[c.kind] || otgMetricsMap[c.kind] && !metricsFiltered(c.kind) {
+			continue
+		}
+		polls = append(polls, &metricsPoll{req: c.request()})
+		added[c.kind] = true
+	}
+	return polls
+}
+
+// Evaluate --stop-on conditions on the latest responses, and abort the run if any of them holds
+func checkStopConditions(api gosnappi.Api, polls []*metricsPoll) {
+	for _, c := range otgStopConditions {
+		for _, p := range polls {
+			reason, ok := c.holds(p.res)
+			if !ok {
+				continue
+			}
+			log.Errorf("Stop condition %s met: %s, terminating at runTraffic after %s", c.text, reason, time.Since(startTime))
+			e := newEvent(EVENT_STOP_CONDITION)
+			e.Condition = c.text
+			e.Reason = reason
+			printEvent(api, e)
+			otgExit(1)
+		}
+	}
+}
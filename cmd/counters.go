@@ -0,0 +1,302 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-traffic-generator/snappi/gosnappi/otg"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Values of counters in the previous samples, for deltas and rates across lines of the metrics stream
+var transformCounterHistory = map[string]counterHistory{}
+
+// Time of the sample being transformed
+var transformSampleTime time.Time
+
+// counterSample is a value of a counter at a point in time
+type counterSample struct {
+	value uint64
+	time  time.Time
+}
+
+// counterHistory is the current and the previous sample of a counter
+type counterHistory struct {
+	prev    counterSample
+	cur     counterSample
+	hasPrev bool
+}
+
+// Change of the counter since the previous sample. A counter that went down was reset, like after traffic was
+// restarted, and counts from zero. The first sample has no change
+func (h counterHistory) delta() uint64 {
+	switch {
+	case !h.hasPrev:
+		return 0
+	case h.cur.value < h.prev.value:
+		return h.cur.value
+	}
+	return h.cur.value - h.prev.value
+}
+
+// Change of the counter per second since the previous sample. Never negative, thanks to reset detection
+func (h counterHistory) rate() float64 {
+	if !h.hasPrev {
+		return 0
+	}
+	dt := h.cur.time.Sub(h.prev.time).Seconds()
+	if dt <= 0 {
+		return 0
+	}
+	return float64(h.delta()) / dt
+}
+
+// Record the value of a counter field of a metrics item in the current sample, and return its history. Reading the
+// same counter again in the same sample doesn't move the history, so that a template can use both delta and rate
+func observeCounter(item protoreflect.Message, field string) counterHistory {
+	fd := item.Descriptor().Fields().ByName(protoreflect.Name(field))
+	if fd == nil || fd.HasPresence() && !item.Has(fd) {
+		return counterHistory{}
+	}
+	var value uint64
+	switch fd.Kind() {
+	case protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+		value = item.Get(fd).Uint()
+	default:
+		log.Fatalf("%s of %s is not a counter", field, item.Descriptor().Name())
+	}
+	name := ""
+	if nf := item.Descriptor().Fields().ByName("name"); nf != nil {
+		name = item.Get(nf).String()
+	}
+	key := string(item.Descriptor().FullName()) + "|" + name + "|" + field
+	h, seen := transformCounterHistory[key]
+	if seen && h.cur.time.Equal(transformSampleTime) {
+		return h
+	}
+	if seen {
+		if value < h.cur.value {
+			log.Debugf("%s of %s was reset from %d to %d", field, name, h.cur.value, value)
+		}
+		h.prev, h.hasPrev = h.cur, true
+	}
+	h.cur = counterSample{value, transformSampleTime}
+	transformCounterHistory[key] = h
+	return h
+}
+
+// Set the time of the sample being transformed, from its RFC3339 timestamp
+func setSampleTime(timestamp string) {
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		t = time.Now()
+	}
+	transformSampleTime = t
+}
+
+// Template functions for counters of metrics items, like {{ delta $f "frames_rx" }} or {{ bps $p "bytes_tx" }}
+var counterTemplateFuncs = map[string]interface{}{
+	"delta": func(item proto.Message, field string) uint64 {
+		return observeCounter(item.ProtoReflect(), field).delta()
+	},
+	"rate": func(item proto.Message, field string) float64 {
+		return observeCounter(item.ProtoReflect(), field).rate()
+	},
+	"bps": func(item proto.Message, field string) float64 {
+		return observeCounter(item.ProtoReflect(), field).rate() * 8
+	},
+	"latency": formatLatency,
+	"latencyUnit": func() string {
+		return transformLatencyUnit
+	},
+	"framesLost": framesLost,
+	"lossPct":    lossPct,
+	"portPairs":  portPairs,
+}
+
+var transformPortPairs []portPair // Ports frames are sent from and received on, for --counters loss of port metrics
+
+// portPair is a port frames are sent from, and a port they are expected to be received on
+type portPair struct {
+	tx string
+	rx string
+}
+
+// Parse port pairs like "p1:p2,p2:p1"
+func parsePortPairs(s string) ([]portPair, error) {
+	pairs := []portPair{}
+	for _, p := range parseNameList(s) {
+		tx, rx, ok := strings.Cut(p, ":")
+		if !ok || strings.TrimSpace(tx) == "" || strings.TrimSpace(rx) == "" {
+			return nil, fmt.Errorf("%s is not a pair of ports like p1:p2", p)
+		}
+		pairs = append(pairs, portPair{strings.TrimSpace(tx), strings.TrimSpace(rx)})
+	}
+	return pairs, nil
+}
+
+// portPairFrames is the number of frames sent from one port of a pair and received on the other one
+type portPairFrames struct {
+	Name     string // Like "p1->p2"
+	FramesTx uint64
+	FramesRx uint64
+}
+
+// Frames of every port pair from --port-pairs. Pairs with a port missing from the metrics are left out
+func portPairs(r *otg.MetricsResponse) []portPairFrames {
+	ports := map[string]*otg.PortMetric{}
+	for _, p := range r.GetPortMetrics() {
+		ports[p.GetName()] = p
+	}
+	frames := []portPairFrames{}
+	for _, pair := range transformPortPairs {
+		tx, rx := ports[pair.tx], ports[pair.rx]
+		if tx == nil || rx == nil {
+			continue
+		}
+		frames = append(frames, portPairFrames{pair.tx + "->" + pair.rx, tx.GetFramesTx(), rx.GetFramesRx()})
+	}
+	return frames
+}
+
+// Frames sent but not received. More frames received than sent, like duplicates, are not a loss
+func framesLost(tx uint64, rx uint64) uint64 {
+	if tx > rx {
+		return tx - rx
+	}
+	return 0
+}
+
+// Frames lost in percent of frames sent
+func lossPct(tx uint64, rx uint64) float64 {
+	if tx == 0 {
+		return 0
+	}
+	return float64(framesLost(tx, rx)) / float64(tx) * 100
+}
+
+// Fields of flow metrics for --counters loss and latency, named the same as in their JSON output
+func flowCounterFields(counter string) []string {
+	switch counter {
+	case COUNTER_LOSS:
+		return []string{"frames_lost", "loss_pct", "loss"}
+	case COUNTER_LATENCY:
+		fields := []string{}
+		for _, stat := range []string{"minimum", "maximum", "average"} {
+			fields = append(fields, "latency_"+stat+"_"+transformLatencyUnit)
+		}
+		return fields
+	}
+	return nil
+}
+
+// Value of a field of flow metrics calculated for --counters loss and latency, as text, formatted the same as in their
+// JSON output. The reported loss is a field of flow metrics of its own
+func flowCounterField(item protoreflect.Message, field string) (string, bool) {
+	f, ok := item.Interface().(*otg.FlowMetric)
+	if !ok {
+		return "", false
+	}
+	switch field {
+	case "frames_lost":
+		return strconv.FormatUint(framesLost(f.GetFramesTx(), f.GetFramesRx()), 10), true
+	case "loss_pct":
+		return strconv.FormatFloat(lossPct(f.GetFramesTx(), f.GetFramesRx()), 'f', 2, 64), true
+	}
+	for _, stat := range []string{"minimum", "maximum", "average"} {
+		if field == "latency_"+stat+"_"+transformLatencyUnit {
+			return formatLatency(f.GetLatency(), stat), true
+		}
+	}
+	return "", false
+}
+
+// Counter a derived field is calculated from, like "frames_rx" for "frames_rx_delta" and "bytes_rx" for "bps_rx".
+// Returns an empty string for other fields
+func derivedCounterBase(field string) string {
+	switch {
+	case strings.HasSuffix(field, "_delta"):
+		return strings.TrimSuffix(field, "_delta")
+	case strings.HasPrefix(field, "bps_"):
+		return "bytes_" + strings.TrimPrefix(field, "bps_")
+	}
+	return ""
+}
+
+// Value of a derived field of a metrics item, like "frames_rx_delta" or "bps_rx", as text
+func derivedCounterField(item protoreflect.Message, field string) (string, bool) {
+	base := derivedCounterBase(field)
+	switch {
+	case base == "":
+		return "", false
+	case strings.HasPrefix(field, "bps_"):
+		return strconv.FormatFloat(observeCounter(item, base).rate()*8, 'f', 0, 64), true
+	}
+	return strconv.FormatUint(observeCounter(item, base).delta(), 10), true
+}
+
+const (
+	LATENCY_NS = "ns"
+	LATENCY_US = "us"
+	LATENCY_MS = "ms"
+)
+
+var transformLatencyUnit string // Unit of latency for --counters latency
+
+// Nanoseconds in every latency unit, and digits after the decimal point to output
+var latencyUnits = map[string]struct {
+	ns        float64
+	precision int
+}{
+	LATENCY_NS: {1, 0},
+	LATENCY_US: {1e3, 3},
+	LATENCY_MS: {1e6, 6},
+}
+
+// Minimum, maximum or average latency in the unit of --latency-unit. Empty if the traffic generator didn't report it,
+// like when latency metrics are not enabled on the flow
+func formatLatency(l *otg.MetricLatency, stat string) string {
+	if l == nil {
+		return ""
+	}
+	var ns *float64
+	switch stat {
+	case "minimum":
+		ns = l.MinimumNs
+	case "maximum":
+		ns = l.MaximumNs
+	case "average":
+		ns = l.AverageNs
+	default:
+		log.Fatalf("Unsupported latency statistic requested: %s", stat)
+	}
+	if ns == nil {
+		return ""
+	}
+	u := latencyUnits[transformLatencyUnit]
+	return strconv.FormatFloat(*ns/u.ns, 'f', u.precision, 64)
+}
@@ -137,9 +137,15 @@ func otgGetDevice(config gosnappi.Config, name string) gosnappi.Device {
 	return nil
 }
 
-// Substitute e with env variable of such name, if it is not empty, otherwise use default vaule d
+// Substitute e with env variable of such name, if it is not empty, otherwise with a value from the selected profile,
+// otherwise use default vaule d
 func envSubstOrDefault(e string, d string) string {
-	s, err := envsubst.EvalEnv(e)
+	s, err := envsubst.Eval(e, func(name string) string {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+		return profileLookup(name)
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,117 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+)
+
+const (
+	TEARDOWN_TIMEOUT = time.Minute // Time limit to stop traffic and protocols on an endpoint, after the run was terminated
+)
+
+var otgApiTimeoutStr string          // Time limit for a single API call
+var otgApiTimeout time.Duration      // Parsed time limit for a single API call
+var otgRunCtx = context.Background() // Context of the run, with a deadline if --timeout was set
+var otgRunCancel context.CancelFunc  // Cancel function of the run context
+var otgPreemptOnce sync.Once
+
+// Set a deadline for all API calls of the run, when a maximum run time was requested
+func startRunDeadline(start time.Time, limit time.Duration) {
+	if limit > 0 {
+		otgRunCtx, otgRunCancel = context.WithDeadline(context.Background(), start.Add(limit))
+	}
+}
+
+// Release the context of the run, once it is over. Calls still waiting on it stop waiting
+func stopRunDeadline() {
+	if otgRunCancel != nil {
+		otgRunCancel()
+	}
+}
+
+// Run an API call on a handle, and stop waiting for it when the context is done, or --api-timeout is exceeded.
+// gosnappi calls don't accept a context, so a call that hangs is abandoned rather than cancelled. As handles are not
+// safe for concurrent calls, the handle stays set aside until the abandoned call completes
+func (a *otgApi) withDeadline(ctx context.Context, name string, handle gosnappi.Api, call func(gosnappi.Api) (interface{}, error)) (interface{}, error) {
+	if otgApiTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, otgApiTimeout)
+		defer cancel()
+	}
+	handle = a.available(handle)
+	if ctx.Done() == nil { // no deadline
+		return call(handle)
+	}
+
+	type result struct {
+		res interface{}
+		err error
+	}
+	done := make(chan result, 1)
+	completed := false // guarded by a.mu
+	go func() {
+		res, err := call(handle)
+		a.mu.Lock()
+		completed = true
+		delete(a.abandoned, handle)
+		a.mu.Unlock()
+		done <- result{res, err}
+	}()
+	select {
+	case r := <-done:
+		return r.res, r.err
+	case <-ctx.Done():
+		a.mu.Lock()
+		if !completed {
+			a.abandoned[handle] = true
+		}
+		a.mu.Unlock()
+		return nil, fmt.Errorf("%s on %s did not complete in time: %w", name, a.location, ctx.Err())
+	}
+}
+
+// Handle to make a call on in place of handle: the handle itself, or a new one while a call abandoned on it is
+// still running
+func (a *otgApi) available(handle gosnappi.Api) gosnappi.Api {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.abandoned[handle] {
+		log.Debugf("A call abandoned on %s is still running, using another connection", a.location)
+		return a.connect()
+	}
+	return handle
+}
+
+// Terminate the run when its deadline was hit during an API call, stopping traffic and protocols
+func (a *otgApi) preempt(name string) {
+	otgPreemptOnce.Do(func() {
+		log.Errorf("Exceeded maximum time limit, terminating at %s on %s after %s", name, a.location, time.Since(startTime))
+		printTimeoutEvent(a, name)
+		otgExit(1)
+	})
+}
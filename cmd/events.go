@@ -0,0 +1,106 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+)
+
+const (
+	// Lifecycle events reported by "run"
+	EVENT_CONFIG_APPLIED     = "config_applied"
+	EVENT_PROTOCOLS_UP       = "protocols_up"
+	EVENT_WARMUP_STARTED     = "warmup_started"
+	EVENT_WARMUP_DONE        = "warmup_done"
+	EVENT_TRAFFIC_STARTED    = "traffic_started"
+	EVENT_RATE_CHANGED       = "rate_changed"
+	EVENT_TRAFFIC_STOPPED    = "traffic_stopped"
+	EVENT_TIMEOUT            = "timeout"
+	EVENT_STOP_CONDITION     = "stop_condition"
+	EVENT_RUN_SUMMARY        = "run_summary"
+	EVENT_ITERATIONS_SUMMARY = "iterations_summary"
+)
+
+var otgEvents bool // Print lifecycle event records to the metrics stream
+
+// otgEvent is a lifecycle event record printed by "run" in between MetricsResponse lines
+type otgEvent struct {
+	Event         string              `json:"event"`                    // Event name
+	Timestamp     string              `json:"timestamp"`                // Time of the event, RFC3339 with nanoseconds
+	ElapsedMs     int64               `json:"elapsed_ms"`               // Time since the start of the run, in milliseconds
+	Protocol      string              `json:"protocol,omitempty"`       // Protocol name, for protocols_up
+	ConvergenceMs int64               `json:"convergence_ms,omitempty"` // Time it took protocols to come up, in milliseconds, for protocols_up
+	Phase         string              `json:"phase,omitempty"`          // Phase of the run or API call that was interrupted, for timeout
+	Rates         map[string]string   `json:"rates,omitempty"`          // New rates by flow name, for rate_changed
+	Condition     string              `json:"condition,omitempty"`      // Condition that was met, for stop_condition
+	Reason        string              `json:"reason,omitempty"`         // Metrics item and value that met the condition, for stop_condition
+	RetriedCalls  *int                `json:"retried_calls,omitempty"`  // Number of API calls that had to be retried, for run_summary
+	Iterations    int                 `json:"iterations,omitempty"`     // Number of iterations completed, for iterations_summary
+	Flows         []otgFlowAggregates `json:"flows,omitempty"`          // Per-flow aggregates across iterations, for iterations_summary
+	Labels        map[string]string   `json:"labels,omitempty"`         // Labels of the endpoint the event came from
+}
+
+func newEvent(name string) otgEvent {
+	now := time.Now()
+	return otgEvent{
+		Event:     name,
+		Timestamp: now.Format(time.RFC3339Nano),
+		ElapsedMs: now.Sub(startTime).Milliseconds(),
+	}
+}
+
+// print event record of the endpoint to stdout, if event records were requested
+func printEvent(api gosnappi.Api, e otgEvent) {
+	if !otgEvents {
+		return
+	}
+	e.Labels = streamLabels(api)
+	printEventRecord(e)
+}
+
+// print event record to stdout as one line of JSON. Characters like ">" in conditions are kept as is
+func printEventRecord(e otgEvent) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(e); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// check if a line from the metrics stream is an event record
+func isEventRecord(text string) bool {
+	var e otgEvent
+	if json.Unmarshal([]byte(text), &e) != nil {
+		return false
+	}
+	return e.Event != ""
+}
+
+func printTimeoutEvent(api gosnappi.Api, phase string) {
+	e := newEvent(EVENT_TIMEOUT)
+	e.Phase = phase
+	printEvent(api, e)
+}
@@ -0,0 +1,333 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var exportApiURL string          // URL of OTG API endpoint to poll metrics from, instead of reading them from stdin
+var exportMetrics string         // Metrics types to poll, as a comma-separated list
+var exportPullIntervalStr string // Interval to poll metrics
+var exportPullInterval time.Duration
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export OTG metrics to monitoring systems",
+	Long: `
+Export OTG metrics to monitoring systems, either from the metrics stream of
+"otgen run" on stdin, or by polling an OTG API endpoint directly.
+
+For more information, go to https://github.com/open-traffic-generator/otgen
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Error("You must specify a monitoring system to export to, one of the following: prometheus, otlp")
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		setLogLevel(cmd, logLevel)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+}
+
+// Add flags to choose the source of metrics to export
+func addExportSourceFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&exportApiURL, "api", "a", "", "URL of OTG API endpoint to poll metrics from. If not provided, metrics are read from stdin, as printed by \"otgen run\"")
+	addApiFlags(cmd)
+	cmd.Flags().StringVarP(&exportMetrics, "metrics", "m", "port,flow", "Metrics types to poll from --api, as a comma-separated list: \"port\", \"flow\", \"bgp4\"")
+	cmd.Flags().StringVarP(&exportPullIntervalStr, "interval", "i", envSubstOrDefault(OTG_INTERVAL, OTG_DEFAULT_INTERVAL), "Interval to poll metrics from --api. Valid time units are 'ms', 's', 'm', 'h'. Overrides ENV:OTG_INTERVAL")
+	profileFlag(cmd, "interval", OTG_INTERVAL, OTG_DEFAULT_INTERVAL)
+}
+
+// Validate flags added by addExportSourceFlags
+func parseExportSourceFlags() {
+	if exportApiURL == "" {
+		return
+	}
+	parseApiFlags()
+	for _, m := range parseNameList(exportMetrics) {
+		if _, ok := conditionKinds[m]; !ok {
+			log.Fatalf("Unsupported metrics type requested: %s", m)
+		}
+	}
+	var err error
+	exportPullInterval, err = time.ParseDuration(exportPullIntervalStr)
+	if err != nil {
+		log.Fatalf("Incorrect format for --interval: %s", err)
+	}
+}
+
+// exportSample is the latest MetricsResponse of one metrics type from one endpoint
+type exportSample struct {
+	mr        gosnappi.MetricsResponse
+	labels    map[string]string // Labels of the record the response came in, like the endpoint and the iteration
+	timestamp time.Time
+}
+
+// exportState keeps the latest metrics of every type from every endpoint, for exporters to read at any time
+type exportState struct {
+	mu      sync.Mutex
+	samples map[string]exportSample // By metrics type and endpoint
+	updates int                     // Number of MetricsResponses received so far
+}
+
+func newExportState() *exportState {
+	return &exportState{samples: map[string]exportSample{}}
+}
+
+func (s *exportState) update(mr gosnappi.MetricsResponse, labels map[string]string, timestamp time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a new iteration replaces metrics of the previous one
+	s.samples[string(mr.Choice())+"|"+labels[LABEL_ENDPOINT]] = exportSample{mr, labels, timestamp}
+	s.updates++
+}
+
+// Latest samples, in the order of metrics types and endpoints
+func (s *exportState) latest() []exportSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for k := range s.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	samples := []exportSample{}
+	for _, k := range keys {
+		samples = append(samples, s.samples[k])
+	}
+	return samples
+}
+
+// Feed the state with metrics from the requested source, until the metrics stream on stdin ends. Polling the API
+// never ends
+func (s *exportState) collect() {
+	if exportApiURL != "" {
+		s.poll()
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" || isEventRecord(text) {
+			continue
+		}
+		if record, responses := parseMetricsRecord(text); responses != nil {
+			timestamp, err := time.Parse(time.RFC3339Nano, record.Timestamp)
+			if err != nil {
+				timestamp = time.Now()
+			}
+			for _, mr := range responses {
+				s.update(mr, record.Labels, timestamp)
+			}
+			continue
+		}
+		mr := gosnappi.NewMetricsResponse()
+		if err := mr.Unmarshal().FromJson(text); err != nil {
+			log.Errorf("Skipping a line that is not a MetricsResponse: %s", err)
+			continue
+		}
+		s.update(mr, nil, time.Now())
+	}
+	if err := scanner.Err(); err != nil {
+		log.Error(err)
+	}
+	log.Info("End of the metrics stream, keeping the last values")
+}
+
+// Poll metrics from the API endpoint on every interval. Failed polls are reported, and the last values are kept
+func (s *exportState) poll() {
+	api := newOtgApi(exportApiURL)
+	requests := []gosnappi.MetricsRequest{}
+	for _, m := range parseNameList(exportMetrics) {
+		requests = append(requests, newMetricsTypeRequest(m))
+	}
+	ticker := time.NewTicker(exportPullInterval)
+	defer ticker.Stop()
+	for {
+		for _, req := range requests {
+			mr, err := api.GetMetrics(req)
+			if err != nil {
+				log.Errorf("Failed to get metrics from %s: %s", exportApiURL, apiError(err))
+				continue
+			}
+			s.update(mr, nil, time.Now())
+		}
+		<-ticker.C
+	}
+}
+
+const (
+	POINT_COUNTER  = "counter"  // Monotonic count, like frames_tx
+	POINT_GAUGE    = "gauge"    // Value that goes up and down, like frames_rx_rate
+	POINT_STATESET = "stateset" // Enum, like link or session_state
+	POINT_INFO     = "info"     // Strings describing the item, like the location of a port
+)
+
+// Metrics type and the label for names of its items, by MetricsResponse choice
+var exportKinds = map[string]struct {
+	prefix string
+	label  string
+}{
+	"port_metrics":  {"port", "port"},
+	"flow_metrics":  {"flow", "flow"},
+	"bgpv4_metrics": {"bgpv4", "peer"},
+	"bgpv6_metrics": {"bgpv6", "peer"},
+	"isis_metrics":  {"isis", "router"},
+	"lag_metrics":   {"lag", "lag"},
+	"lacp_metrics":  {"lacp", "lag_member"},
+	"lldp_metrics":  {"lldp", "lldp"},
+	"rsvp_metrics":  {"rsvp", "router"},
+}
+
+// Integer fields that are current values rather than counts since the start
+var exportGaugeFields = map[string]bool{
+	"routes_advertised": true,
+	"routes_received":   true,
+	"l1_sessions_up":    true,
+	"l2_sessions_up":    true,
+	"l1_database_size":  true,
+	"l2_database_size":  true,
+}
+
+// exportPoint is a value of one field of a metrics item
+type exportPoint struct {
+	name   string            // Metrics type and field, like "port_frames_tx" or "flow_latency_average_ns"
+	metric string            // Metrics type, like "port" or "bgpv4"
+	field  string            // Field, like "frames_tx" or "latency_average_ns". Empty for POINT_INFO
+	kind   string            // POINT_COUNTER, POINT_GAUGE, POINT_STATESET or POINT_INFO
+	labels map[string]string // Name of the item, like {"port": "p1"}, and labels of the record it came in
+	value  float64
+	state  string            // Current state, for POINT_STATESET
+	states []string          // All the states, for POINT_STATESET
+	info   map[string]string // String fields, for POINT_INFO
+}
+
+// Values of all the fields of all the items in the sample. Nested messages, like flow latency, are flattened into
+// gauges
+func (s exportSample) points() []exportPoint {
+	msg, err := s.mr.Marshal().ToProto()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pm := msg.ProtoReflect()
+	choice := string(s.mr.Choice())
+	fd := pm.Descriptor().Fields().ByName(protoreflect.Name(choice))
+	if fd == nil || !fd.IsList() {
+		return nil
+	}
+	k, ok := exportKinds[choice]
+	if !ok {
+		k.prefix, k.label = strings.TrimSuffix(choice, "_metrics"), "name"
+	}
+	points := []exportPoint{}
+	list := pm.Get(fd).List()
+	for i := 0; i < list.Len(); i++ {
+		item := list.Get(i).Message()
+		labels := map[string]string{}
+		for l, v := range s.labels {
+			labels[l] = v
+		}
+		labels[k.label] = item.Get(item.Descriptor().Fields().ByName("name")).String()
+		info := map[string]string{}
+		points = appendPoints(points, k.prefix, "", item, labels, info)
+		if len(info) > 0 {
+			points = append(points, exportPoint{name: k.prefix, metric: k.prefix, kind: POINT_INFO, labels: labels, value: 1, info: info})
+		}
+		for kind, c := range conditionKinds {
+			if c.choice != choice {
+				continue
+			}
+			for field, derive := range derivedFields[kind] {
+				points = append(points, exportPoint{name: k.prefix + "_" + field, metric: k.prefix, field: field, kind: POINT_GAUGE, labels: labels, value: derive(s.mr, i)})
+			}
+		}
+	}
+	return points
+}
+
+// Append points for the fields of a metrics item, or of a message nested in it under the parent field
+func appendPoints(points []exportPoint, metric string, parent string, m protoreflect.Message, labels map[string]string, info map[string]string) []exportPoint {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.IsList() || fd.IsMap() || fd.Name() == "name" || fd.HasPresence() && !m.Has(fd) {
+			continue
+		}
+		field := string(fd.Name())
+		if parent != "" {
+			field = parent + "_" + field
+		}
+		v := m.Get(fd)
+		p := exportPoint{name: metric + "_" + field, metric: metric, field: field, kind: POINT_GAUGE, labels: labels}
+		switch fd.Kind() {
+		case protoreflect.MessageKind:
+			points = appendPoints(points, metric, field, v.Message(), labels, info)
+			continue
+		case protoreflect.StringKind:
+			info[field] = v.String()
+			continue
+		case protoreflect.EnumKind:
+			p.kind = POINT_STATESET
+			values := fd.Enum().Values()
+			for j := 0; j < values.Len(); j++ {
+				if values.Get(j).Number() != 0 { // 0 is "unspecified"
+					p.states = append(p.states, string(values.Get(j).Name()))
+				}
+			}
+			if ev := values.ByNumber(v.Enum()); ev != nil {
+				p.state = string(ev.Name())
+			}
+		case protoreflect.BoolKind:
+			if v.Bool() {
+				p.value = 1
+			}
+		case protoreflect.FloatKind, protoreflect.DoubleKind:
+			p.value = v.Float()
+		case protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+			p.value = float64(v.Uint())
+			if parent == "" && !exportGaugeFields[field] {
+				p.kind = POINT_COUNTER
+			}
+		case protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Sint32Kind, protoreflect.Sint64Kind, protoreflect.Sfixed32Kind, protoreflect.Sfixed64Kind:
+			p.value = float64(v.Int())
+		default:
+			continue
+		}
+		points = append(points, p)
+	}
+	return points
+}
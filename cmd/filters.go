@@ -0,0 +1,206 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"path"
+	"strings"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+	"github.com/open-traffic-generator/snappi/gosnappi/otg"
+)
+
+// Valid column names for --port-columns, --flow-columns and --bgp-columns
+var (
+	otgPortColumnNames = otg.PortMetricsRequest_ColumnNames_Enum_value
+	otgFlowColumnNames = otg.FlowMetricsRequest_MetricNames_Enum_value
+	otgBgp4ColumnNames = otg.Bgpv4MetricsRequest_ColumnNames_Enum_value
+)
+
+// Parse a comma-separated list of names or glob patterns. An empty string results in an empty list
+func parseNameList(s string) []string {
+	names := []string{}
+	for _, n := range strings.Split(s, ",") {
+		n = strings.TrimSpace(n)
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// Parse a comma-separated list of metric columns, and check each of them is present in a list of valid column names
+func parseColumnList(flag string, s string, valid map[string]int32) []string {
+	columns := parseNameList(s)
+	for _, c := range columns {
+		if _, ok := valid[c]; !ok || c == "unspecified" {
+			log.Fatalf("Unsupported column requested with --%s: %s", flag, c)
+		}
+	}
+	return columns
+}
+
+// Match names against a list of glob patterns. Every pattern has to match at least one name
+func matchNames(kind string, patterns []string, names []string) []string {
+	matched := []string{}
+	seen := make(map[string]bool)
+	for _, p := range patterns {
+		found := false
+		for _, n := range names {
+			ok, err := path.Match(p, n)
+			if err != nil {
+				log.Fatalf("Incorrect %s name pattern %s: %s", kind, p, err)
+			}
+			if ok {
+				found = true
+				if !seen[n] {
+					seen[n] = true
+					matched = append(matched, n)
+				}
+			}
+		}
+		if !found {
+			log.Fatalf("No %s in the configuration matches %s", kind, p)
+		}
+	}
+	return matched
+}
+
+func configPortNames(config gosnappi.Config) []string {
+	names := []string{}
+	for _, p := range config.Ports().Items() {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+func configFlowNames(config gosnappi.Config) []string {
+	names := []string{}
+	for _, f := range config.Flows().Items() {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+func configBgp4PeerNames(config gosnappi.Config) []string {
+	names := []string{}
+	for _, d := range config.Devices().Items() {
+		if d.HasBgp() {
+			for _, i := range d.Bgp().Ipv4Interfaces().Items() {
+				for _, p := range i.Peers().Items() {
+					names = append(names, p.Name())
+				}
+			}
+		}
+	}
+	return names
+}
+
+// check if metrics of the kind are requested for a subset of names or columns
+func metricsFiltered(kind string) bool {
+	switch kind {
+	case "port":
+		return len(otgPortNames) > 0 || len(otgPortColumns) > 0
+	case "flow":
+		return len(otgFlowNames) > 0 || len(otgFlowColumns) > 0
+	case "bgp4":
+		return len(otgBgp4PeerNames) > 0 || len(otgBgp4Columns) > 0
+	}
+	return false
+}
+
+// Create a request for port metrics to report, with names and columns selected via --ports and --port-columns
+func newPortMetricsRequest(config gosnappi.Config) gosnappi.MetricsRequest {
+	req := gosnappi.NewMetricsRequest()
+	pr := req.Port()
+	if len(otgPortNames) > 0 {
+		pr.SetPortNames(matchNames("port", otgPortNames, configPortNames(config)))
+	}
+	if len(otgPortColumns) > 0 {
+		columns := []gosnappi.PortMetricsRequestColumnNamesEnum{}
+		for _, c := range otgPortColumns {
+			columns = append(columns, gosnappi.PortMetricsRequestColumnNamesEnum(c))
+		}
+		pr.SetColumnNames(columns)
+	}
+	return req
+}
+
+// Create a request for flow metrics to report, with names and columns selected via --flows and --flow-columns
+func newFlowMetricsRequest(config gosnappi.Config) gosnappi.MetricsRequest {
+	req := gosnappi.NewMetricsRequest()
+	fr := req.Flow()
+	if len(otgFlowNames) > 0 {
+		fr.SetFlowNames(matchNames("flow", otgFlowNames, configFlowNames(config)))
+	}
+	if len(otgFlowColumns) > 0 {
+		columns := []gosnappi.FlowMetricsRequestMetricNamesEnum{}
+		for _, c := range otgFlowColumns {
+			columns = append(columns, gosnappi.FlowMetricsRequestMetricNamesEnum(c))
+		}
+		fr.SetMetricNames(columns)
+	}
+	return req
+}
+
+// Create a request for BGPv4 metrics to report, with names and columns selected via --bgp-peers and --bgp-columns
+func newBgp4MetricsRequest(config gosnappi.Config) gosnappi.MetricsRequest {
+	req := gosnappi.NewMetricsRequest()
+	br := req.Bgpv4()
+	if len(otgBgp4PeerNames) > 0 {
+		br.SetPeerNames(matchNames("BGP peer", otgBgp4PeerNames, configBgp4PeerNames(config)))
+	}
+	if len(otgBgp4Columns) > 0 {
+		columns := []gosnappi.Bgpv4MetricsRequestColumnNamesEnum{}
+		for _, c := range otgBgp4Columns {
+			columns = append(columns, gosnappi.Bgpv4MetricsRequestColumnNamesEnum(c))
+		}
+		br.SetColumnNames(columns)
+	}
+	return req
+}
+
+// Metrics request for all the items and fields of a metrics type: "port", "flow" or "bgp4"
+func newMetricsTypeRequest(kind string) gosnappi.MetricsRequest {
+	req := gosnappi.NewMetricsRequest()
+	switch kind {
+	case "port":
+		req.Port()
+	case "flow":
+		req.Flow()
+	case "bgp4":
+		req.Bgpv4()
+	}
+	return req
+}
+
+// Create a request for the minimum of port or flow metrics needed to tell if traffic is still running, for all ports or flows
+func newTrafficStateRequest(kind string) gosnappi.MetricsRequest {
+	req := gosnappi.NewMetricsRequest()
+	switch kind {
+	case "flow":
+		req.Flow().SetMetricNames([]gosnappi.FlowMetricsRequestMetricNamesEnum{gosnappi.FlowMetricsRequestMetricNames.TRANSMIT})
+	default:
+		req.Port().SetColumnNames([]gosnappi.PortMetricsRequestColumnNamesEnum{gosnappi.PortMetricsRequestColumnNames.FRAMES_TX})
+	}
+	return req
+}
@@ -225,6 +225,8 @@ func init() {
 
 	flowCmd.Flags().StringVarP(&flowSrcMac, "smac", "S", envSubstOrDefault(MAC_SRC_TX, MAC_DEFAULT_SRC), "Source MAC address. For device-bound flows, default value is copied from the Tx device")
 	flowCmd.Flags().StringVarP(&flowDstMac, "dmac", "D", envSubstOrDefault(MAC_DST_TX, MAC_DEFAULT_DST), "Destination MAC address. For device-bound flows, default value \"auto\" enables ARP for IPv4 / ND for IPv6")
+	profileFlag(flowCmd, "smac", MAC_SRC_TX, MAC_DEFAULT_SRC)
+	profileFlag(flowCmd, "dmac", MAC_DST_TX, MAC_DEFAULT_DST)
 
 	flowCmd.Flags().BoolVarP(&flowIPv4, "ipv4", "4", true, "IP Version 4")
 	flowCmd.Flags().BoolVarP(&flowIPv6, "ipv6", "6", false, "IP Version 6")
@@ -232,6 +234,8 @@ func init() {
 
 	flowCmd.Flags().StringVarP(&flowSrc, "src", "s", envSubstOrDefault(IPV4_SRC, IPV4_DEFAULT_SRC), "Source IP address")
 	flowCmd.Flags().StringVarP(&flowDst, "dst", "d", envSubstOrDefault(IPV4_DST, IPV4_DEFAULT_DST), "Destination IP address")
+	profileFlag(flowCmd, "src", IPV4_SRC, IPV4_DEFAULT_SRC)
+	profileFlag(flowCmd, "dst", IPV4_DST, IPV4_DEFAULT_DST)
 
 	// Transport protocol
 	flowCmd.Flags().StringVarP(&flowProto, "proto", "P", PROTO_TCP, "IP transport protocol: \"icmp\" | \"tcp\" | \"udp\"")
@@ -0,0 +1,464 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+	"github.com/open-traffic-generator/snappi/gosnappi/otg"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	FORMAT_JSON     = "json"     // JSON arrays from built-in templates, for display
+	FORMAT_CSV      = "csv"      // Comma-separated values with a header row
+	FORMAT_TSV      = "tsv"      // Tab-separated values with a header row
+	FORMAT_INFLUX   = "influx"   // InfluxDB line protocol, one line per metrics item
+	FORMAT_GRAPHITE = "graphite" // Graphite plaintext protocol, one line per field of a metrics item
+)
+
+const (
+	LINE_PREFIX = "otg" // Prefix of InfluxDB measurements and Graphite paths
+)
+
+var transformFormat string // Output format of transform
+var transformSink string   // URL of a socket to write lines to, instead of stdout
+
+// Fields of metrics items for every counter, in the order of columns
+var transformCounterFields = map[string][]string{
+	COUNTER_FRAMES: {"frames_tx", "frames_rx"},
+	COUNTER_BYTES:  {"bytes_tx", "bytes_rx"},
+	COUNTER_PPS:    {"frames_tx_rate", "frames_rx_rate"},
+	COUNTER_TPUT:   {"bytes_tx_rate", "bytes_rx_rate"},
+	COUNTER_DELTA:  {"frames_tx_delta", "frames_rx_delta"},
+	COUNTER_BPS:    {"bps_tx", "bps_rx"},
+}
+
+const (
+	COUNTER_SESSION  = "session"  // Session state and flaps of a protocol
+	COUNTER_ROUTES   = "routes"   // Routes of a protocol
+	COUNTER_MESSAGES = "messages" // Protocol messages sent and received
+)
+
+// Fields of protocol metrics items for every counter, by metrics type
+var transformProtocolCounterFields = map[string]map[string][]string{
+	METRIC_BGP4: {
+		COUNTER_SESSION:  {"session_state", "session_flap_count"},
+		COUNTER_ROUTES:   {"routes_advertised", "routes_received", "route_withdraws_sent", "route_withdraws_received"},
+		COUNTER_MESSAGES: {"updates_sent", "updates_received", "keepalives_sent", "keepalives_received"},
+	},
+	METRIC_BGP6: {
+		COUNTER_SESSION:  {"session_state", "session_flap_count"},
+		COUNTER_ROUTES:   {"routes_advertised", "routes_received", "route_withdraws_sent", "route_withdraws_received"},
+		COUNTER_MESSAGES: {"updates_sent", "updates_received", "keepalives_sent", "keepalives_received"},
+	},
+	METRIC_ISIS: {
+		COUNTER_SESSION:  {"l1_sessions_up", "l1_session_flap", "l2_sessions_up", "l2_session_flap"},
+		COUNTER_ROUTES:   {"l1_database_size", "l2_database_size"},
+		COUNTER_MESSAGES: {"l1_lsp_sent", "l1_lsp_received", "l2_lsp_sent", "l2_lsp_received"},
+	},
+}
+
+// Descriptor of items of a metrics type, like otg.PortMetric for "port"
+func metricsItemDescriptor(metrics string) protoreflect.MessageDescriptor {
+	fd := (&otg.MetricsResponse{}).ProtoReflect().Descriptor().Fields().ByName(protoreflect.Name(transformMetricsChoice[metrics]))
+	return fd.Message()
+}
+
+// Parse counters as a comma-separated list of counter names and fields of metrics items, into a list of fields
+func parseCounterFields(metrics string, counters string) ([]string, error) {
+	groups, protocol := transformProtocolCounterFields[metrics]
+	if !protocol {
+		groups = transformCounterFields
+	}
+	if counters == "" && protocol {
+		counters = strings.Join([]string{COUNTER_SESSION, COUNTER_ROUTES, COUNTER_MESSAGES}, ",")
+	} else if counters == "" {
+		counters = COUNTER_FRAMES
+	}
+	item := metricsItemDescriptor(metrics)
+	fields := []string{}
+	flowFields := map[string]bool{} // fields calculated for --counters loss and latency
+	for _, c := range strings.Split(counters, ",") {
+		c = strings.TrimSpace(c)
+		if f, ok := groups[c]; ok {
+			fields = append(fields, f...)
+			continue
+		}
+		if f := flowCounterFields(c); f != nil && !protocol {
+			switch {
+			case c == COUNTER_LOSS && metrics == METRIC_PORT:
+				return nil, fmt.Errorf("%s of port metrics is calculated for --port-pairs, supported only with --format %s", c, FORMAT_JSON)
+			case metrics != METRIC_FLOW:
+				return nil, fmt.Errorf("%s is supported only for flow metrics", c)
+			}
+			for _, ff := range f {
+				fields = append(fields, ff)
+				flowFields[ff] = true
+			}
+			continue
+		}
+		if base := derivedCounterBase(c); base != "" && isCounterField(item, base) {
+			fields = append(fields, c)
+			continue
+		}
+		if fd := item.Fields().ByName(protoreflect.Name(c)); fd == nil || fd.IsList() || fd.Message() != nil || c == "name" {
+			return nil, fmt.Errorf("%s is neither a counter nor a field of %s metrics", c, metrics)
+		}
+		fields = append(fields, c)
+	}
+	for _, f := range fields {
+		if flowFields[f] {
+			continue
+		}
+		if base := derivedCounterBase(f); base != "" && !isCounterField(item, base) || base == "" && item.Fields().ByName(protoreflect.Name(f)) == nil {
+			return nil, fmt.Errorf("%s metrics have no %s", metrics, f)
+		}
+	}
+	return fields, nil
+}
+
+// Check if a field of metrics items is a counter that deltas and rates can be calculated for
+func isCounterField(item protoreflect.MessageDescriptor, field string) bool {
+	fd := item.Fields().ByName(protoreflect.Name(field))
+	if fd == nil || fd.IsList() {
+		return false
+	}
+	switch fd.Kind() {
+	case protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+		return true
+	}
+	return false
+}
+
+// rowWriter writes one row per metrics item, with a header row before the first one
+type rowWriter struct {
+	w      *csv.Writer
+	fields []string
+	header bool // the header row was written
+}
+
+func newRowWriter(format string, fields []string) *rowWriter {
+	w := csv.NewWriter(os.Stdout)
+	if format == FORMAT_TSV {
+		w.Comma = '\t'
+	}
+	return &rowWriter{w: w, fields: fields}
+}
+
+// Write the items of the MetricsResponse of the requested metrics type as rows
+func (rw *rowWriter) write(mr gosnappi.MetricsResponse, timestamp string) {
+	choice := transformMetricsChoice[transformMetrics]
+	if string(mr.Choice()) != choice {
+		return
+	}
+	setSampleTime(timestamp)
+	if !rw.header {
+		rw.writeRow(append([]string{"timestamp", "name"}, rw.fields...))
+		rw.header = true
+	}
+	msg, err := mr.Marshal().ToProto()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pm := msg.ProtoReflect()
+	list := pm.Get(pm.Descriptor().Fields().ByName(protoreflect.Name(choice))).List()
+	for i := 0; i < list.Len(); i++ {
+		item := list.Get(i).Message()
+		row := []string{timestamp, item.Get(item.Descriptor().Fields().ByName("name")).String()}
+		for _, f := range rw.fields {
+			if v, ok := flowCounterField(item, f); ok {
+				row = append(row, v)
+				continue
+			}
+			if v, ok := derivedCounterField(item, f); ok {
+				row = append(row, v)
+				continue
+			}
+			row = append(row, formatField(item, f))
+		}
+		rw.writeRow(row)
+	}
+}
+
+func (rw *rowWriter) writeRow(row []string) {
+	if err := rw.w.Write(row); err != nil {
+		log.Fatal(err)
+	}
+	rw.w.Flush() // every sample is output as soon as it is read
+	if err := rw.w.Error(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// itemWriter writes the requested fields of metrics items as a JSON array, one line per MetricsResponse, like built-in
+// templates do
+type itemWriter struct {
+	fields []string
+}
+
+// Write the items of the MetricsResponse of the requested metrics type
+func (iw *itemWriter) write(mr gosnappi.MetricsResponse, timestamp string) {
+	choice := transformMetricsChoice[transformMetrics]
+	if string(mr.Choice()) != choice {
+		return
+	}
+	setSampleTime(timestamp)
+	msg, err := mr.Marshal().ToProto()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pm := msg.ProtoReflect()
+	list := pm.Get(pm.Descriptor().Fields().ByName(protoreflect.Name(choice))).List()
+	items := []string{}
+	for i := 0; i < list.Len(); i++ {
+		item := list.Get(i).Message()
+		pairs := []string{jsonPair("name", item.Get(item.Descriptor().Fields().ByName("name")).String())}
+		for _, f := range iw.fields {
+			v, ok := derivedCounterField(item, f)
+			if !ok {
+				v = formatField(item, f)
+			}
+			pairs = append(pairs, jsonPair(f, v))
+		}
+		items = append(items, "{"+strings.Join(pairs, ", ")+"}")
+	}
+	fmt.Println("[" + strings.Join(items, ",") + "]")
+}
+
+// Format a key and a string value as a member of a JSON object, like "name": "p1"
+func jsonPair(k string, v string) string {
+	kj, _ := marshalSelectJSON(k)
+	vj, _ := marshalSelectJSON(v)
+	return string(kj) + ": " + string(vj)
+}
+
+// Format a field of a metrics item as text. Fields the traffic generator did not report are empty
+func formatField(item protoreflect.Message, field string) string {
+	fd := item.Descriptor().Fields().ByName(protoreflect.Name(field))
+	if fd == nil || fd.HasPresence() && !item.Has(fd) {
+		return ""
+	}
+	v := item.Get(fd)
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		return string(fd.Enum().Values().ByNumber(v.Enum()).Name())
+	case protoreflect.FloatKind:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32)
+	case protoreflect.DoubleKind:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+	return v.String()
+}
+
+// lineWriter writes metrics items as InfluxDB or Graphite lines, to stdout or to a socket
+type lineWriter struct {
+	format string
+	w      io.Writer
+	sink   string
+}
+
+func newLineWriter(format string, sink string) *lineWriter {
+	lw := &lineWriter{format: format, w: os.Stdout}
+	if sink != "" {
+		conn, err := dialSink(sink)
+		if err != nil {
+			log.Fatalf("Incorrect sink %s: %s", sink, err)
+		}
+		lw.w, lw.sink = conn, sink
+	}
+	return lw
+}
+
+// Connect to a sink like udp://127.0.0.1:8089 or tcp://127.0.0.1:2003
+func dialSink(sink string) (net.Conn, error) {
+	u, err := url.Parse(sink)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q, use udp:// or tcp://", u.Scheme)
+	}
+	if u.Host == "" || u.Port() == "" {
+		return nil, fmt.Errorf("host and port are required, like %s://127.0.0.1:8089", u.Scheme)
+	}
+	return net.Dial(u.Scheme, u.Host)
+}
+
+// Write the items of the MetricsResponse as lines, with the time of the sample. With --metrics, only items of that
+// metrics type are written
+func (lw *lineWriter) write(mr gosnappi.MetricsResponse, timestamp string) {
+	if transformMetrics != "" && string(mr.Choice()) != transformMetricsChoice[transformMetrics] {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		t = time.Now()
+	}
+	items := [][]exportPoint{} // points of every item, in the order of items
+	index := map[string]int{}
+	for _, p := range (exportSample{mr: mr, timestamp: t}).points() {
+		k := formatLabels(p.labels, nil)
+		i, ok := index[k]
+		if !ok {
+			i = len(items)
+			index[k] = i
+			items = append(items, nil)
+		}
+		items[i] = append(items[i], p)
+	}
+	for _, points := range items {
+		var lines []string
+		if lw.format == FORMAT_INFLUX {
+			lines = influxLines(points, t)
+		} else {
+			lines = graphiteLines(points, t)
+		}
+		for _, l := range lines {
+			lw.writeLine(l)
+		}
+	}
+}
+
+// Write a line. Every line is written on its own, so that a UDP sink gets one record per datagram. Failures to write
+// to a sink are reported, and the following lines are still written
+func (lw *lineWriter) writeLine(line string) {
+	if _, err := io.WriteString(lw.w, line+"\n"); err != nil {
+		if lw.sink == "" {
+			log.Fatal(err)
+		}
+		log.Errorf("Failed to write to %s: %s", lw.sink, err)
+	}
+}
+
+// Format the points of a metrics item as an InfluxDB line, like
+// otg_port,port=p1 frames_tx=100i,frames_rx=98i,link="up" 1654884131000000000
+func influxLines(points []exportPoint, t time.Time) []string {
+	fields := []string{}
+	info := []string{}
+	for _, p := range points {
+		switch p.kind {
+		case POINT_COUNTER:
+			fields = append(fields, escapeInflux(p.field, ",= ")+"="+strconv.FormatUint(uint64(p.value), 10)+"i")
+		case POINT_GAUGE:
+			if math.IsNaN(p.value) || math.IsInf(p.value, 0) { // not allowed in line protocol
+				continue
+			}
+			fields = append(fields, escapeInflux(p.field, ",= ")+"="+formatValue(p.value))
+		case POINT_STATESET:
+			fields = append(fields, escapeInflux(p.field, ",= ")+"="+quoteInflux(p.state))
+		case POINT_INFO:
+			for k, v := range p.info {
+				info = append(info, escapeInflux(k, ",= ")+"="+quoteInflux(v))
+			}
+			sort.Strings(info)
+		}
+	}
+	fields = append(fields, info...)
+	if len(fields) == 0 {
+		return nil
+	}
+	tags := []string{}
+	for k, v := range points[0].labels {
+		tags = append(tags, escapeInflux(k, ",= ")+"="+escapeInflux(v, ",= "))
+	}
+	sort.Strings(tags)
+	measurement := escapeInflux(LINE_PREFIX+"_"+points[0].metric, ", ")
+	if len(tags) > 0 {
+		measurement += "," + strings.Join(tags, ",")
+	}
+	return []string{measurement + " " + strings.Join(fields, ",") + " " + strconv.FormatInt(t.UnixNano(), 10)}
+}
+
+// Escape characters with a backslash, as line protocol requires for measurements, tag keys and values, and field keys
+func escapeInflux(s string, chars string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(chars, c) || c == '\\' {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func quoteInflux(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Format the points of a metrics item as Graphite lines, like otg.port.p1.frames_tx 100 1654884131. States are
+// written as one line per state, with 1 for the current one, and strings are left out
+func graphiteLines(points []exportPoint, t time.Time) []string {
+	path := []string{LINE_PREFIX, points[0].metric}
+	labels := []string{}
+	for k := range points[0].labels {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+	for _, k := range labels {
+		path = append(path, graphiteNode(points[0].labels[k]))
+	}
+	prefix := strings.Join(path, ".") + "."
+	ts := " " + strconv.FormatInt(t.Unix(), 10)
+	lines := []string{}
+	for _, p := range points {
+		switch p.kind {
+		case POINT_COUNTER, POINT_GAUGE:
+			if math.IsNaN(p.value) || math.IsInf(p.value, 0) {
+				continue
+			}
+			lines = append(lines, prefix+p.field+" "+formatValue(p.value)+ts)
+		case POINT_STATESET:
+			for _, s := range p.states {
+				v := "0"
+				if s == p.state {
+					v = "1"
+				}
+				lines = append(lines, prefix+p.field+"."+graphiteNode(s)+" "+v+ts)
+			}
+		}
+	}
+	return lines
+}
+
+// Replace characters that separate or are not allowed in nodes of a Graphite path, like "." and spaces, with "_"
+func graphiteNode(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
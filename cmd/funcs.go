@@ -0,0 +1,280 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Template functions for custom templates of transform --file, on top of the functions for counters
+var templateFuncs = map[string]interface{}{
+	// arithmetic on any numbers, like {{ div $f.FramesRx $f.FramesTx }}. Division by zero is zero
+	"add": func(a, b interface{}) float64 { return toFloat(a) + toFloat(b) },
+	"sub": func(a, b interface{}) float64 { return toFloat(a) - toFloat(b) },
+	"mul": func(a, b interface{}) float64 { return toFloat(a) * toFloat(b) },
+	"div": func(a, b interface{}) float64 {
+		if toFloat(b) == 0 {
+			return 0
+		}
+		return toFloat(a) / toFloat(b)
+	},
+	"pct": func(a, b interface{}) float64 {
+		if toFloat(b) == 0 {
+			return 0
+		}
+		return toFloat(a) / toFloat(b) * 100
+	},
+	"round": func(v interface{}, digits int) string {
+		return strconv.FormatFloat(toFloat(v), 'f', digits, 64)
+	},
+
+	// units, like {{ humanizeBits (bps $p "bytes_tx") }}/s
+	"humanizeBytes":    func(v interface{}) string { return humanizeSI(toFloat(v), "B") },
+	"humanizeBits":     func(v interface{}) string { return humanizeSI(toFloat(v), "b") },
+	"humanizeNumber":   func(v interface{}) string { return humanizeSI(toFloat(v), "") },
+	"humanizeDuration": humanizeDuration,
+
+	// strings, of any value, like enums of metrics {{ upper $f.Transmit }}
+	"str":       toString,
+	"upper":     func(v interface{}) string { return strings.ToUpper(toString(v)) },
+	"lower":     func(v interface{}) string { return strings.ToLower(toString(v)) },
+	"trim":      func(v interface{}) string { return strings.TrimSpace(toString(v)) },
+	"replace":   func(old, new string, v interface{}) string { return strings.ReplaceAll(toString(v), old, new) },
+	"contains":  func(substr string, v interface{}) bool { return strings.Contains(toString(v), substr) },
+	"hasPrefix": func(prefix string, v interface{}) bool { return strings.HasPrefix(toString(v), prefix) },
+	"hasSuffix": func(suffix string, v interface{}) bool { return strings.HasSuffix(toString(v), suffix) },
+	"split":     func(sep string, v interface{}) []string { return strings.Split(toString(v), sep) },
+	"join":      func(sep string, elems []string) string { return strings.Join(elems, sep) },
+	"repeat":    func(n int, s string) string { return strings.Repeat(s, n) },
+	"padLeft":   func(n int, v interface{}) string { return padding(toString(v), n) + toString(v) },
+	"padRight":  func(n int, v interface{}) string { return toString(v) + padding(toString(v), n) },
+
+	// time, like {{ formatTime "15:04:05" timestamp }}
+	"now":        time.Now,
+	"timestamp":  func() time.Time { return transformSampleTime },
+	"formatTime": formatTime,
+
+	// builders, like {{ toJson (dict "name" $f.Name "rx" $f.FramesRx) }}
+	"dict": func(pairs ...interface{}) map[string]interface{} {
+		if len(pairs)%2 != 0 {
+			log.Fatalf("Incorrect number of arguments for dict, expected key and value pairs: %d", len(pairs))
+		}
+		d := map[string]interface{}{}
+		for i := 0; i < len(pairs); i += 2 {
+			k, ok := pairs[i].(string)
+			if !ok {
+				log.Fatalf("Incorrect dict key, expected a string: %v", pairs[i])
+			}
+			d[k] = pairs[i+1]
+		}
+		return d
+	},
+	"list":   func(items ...interface{}) []interface{} { return items },
+	"toJson": toJson,
+}
+
+// Text of any value, following pointers like optional fields of metrics. Unset fields are empty
+func toString(v interface{}) string {
+	r := reflect.ValueOf(v)
+	for r.Kind() == reflect.Ptr {
+		if r.IsNil() {
+			return ""
+		}
+		if s, ok := r.Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+		r = r.Elem()
+	}
+	if !r.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(r.Interface())
+}
+
+// Spaces to pad a string to a width of n characters
+func padding(s string, n int) string {
+	if l := len([]rune(s)); l < n {
+		return strings.Repeat(" ", n-l)
+	}
+	return ""
+}
+
+// Value of a number of any type as a float, following pointers like optional fields of metrics. Anything else is
+// fatal, as a template that doesn't add up is better fixed than silently printing zeros
+func toFloat(v interface{}) float64 {
+	r := reflect.ValueOf(v)
+	for r.Kind() == reflect.Ptr {
+		if r.IsNil() {
+			return 0
+		}
+		r = r.Elem()
+	}
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(r.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(r.Uint())
+	case reflect.Float32, reflect.Float64:
+		return r.Float()
+	case reflect.String:
+		f, err := strconv.ParseFloat(r.String(), 64)
+		if err == nil {
+			return f
+		}
+	}
+	log.Fatalf("Incorrect number in template: %v", v)
+	return 0
+}
+
+var siPrefixes = []string{"", "k", "M", "G", "T", "P", "E"}
+
+// Value with an SI prefix and a unit, like "1.25 Gb" for 1250000000 bits, or "1.25G" without a unit
+func humanizeSI(v float64, unit string) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return formatValue(v) + " " + unit
+	}
+	i := 0
+	for math.Abs(v) >= 1000 && i < len(siPrefixes)-1 {
+		v /= 1000
+		i++
+	}
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	if i == 0 && v == math.Trunc(v) {
+		s = strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	if unit == "" {
+		return s + siPrefixes[i]
+	}
+	return s + " " + siPrefixes[i] + unit
+}
+
+// Duration of a number of nanoseconds, like latency, or a Go duration string, rounded to 3 significant digits, like
+// "1.23ms"
+func humanizeDuration(v interface{}) string {
+	var d time.Duration
+	switch x := v.(type) {
+	case time.Duration:
+		d = x
+	case string:
+		p, err := time.ParseDuration(x)
+		if err != nil {
+			d = time.Duration(toFloat(x))
+		} else {
+			d = p
+		}
+	default:
+		d = time.Duration(toFloat(v))
+	}
+	for r := time.Duration(1); r < time.Hour; r *= 10 {
+		if d < 1000*r && d > -1000*r {
+			return d.Round(r).String()
+		}
+	}
+	return d.Round(time.Second).String()
+}
+
+// Names of common time layouts for formatTime
+var timeLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"DateTime":    "2006-01-02 15:04:05",
+	"DateOnly":    "2006-01-02",
+	"TimeOnly":    "15:04:05",
+	"Kitchen":     time.Kitchen,
+}
+
+// Format a time or an RFC3339 timestamp with a named layout or a Go layout. Layout "unix" gives seconds since the
+// epoch
+func formatTime(layout string, t interface{}) string {
+	var tm time.Time
+	switch x := t.(type) {
+	case time.Time:
+		tm = x
+	case string:
+		p, err := time.Parse(time.RFC3339Nano, x)
+		if err != nil {
+			log.Fatalf("Incorrect timestamp, expected RFC3339: %s", x)
+		}
+		tm = p
+	default:
+		log.Fatalf("Incorrect time: %v", t)
+	}
+	if layout == "unix" {
+		return strconv.FormatInt(tm.Unix(), 10)
+	}
+	if l, ok := timeLayouts[layout]; ok {
+		layout = l
+	}
+	return tm.Format(layout)
+}
+
+// JSON of any value. Metrics are marshalled with proto field names, like in otgMetricsResponseToJson, also inside
+// lists and dicts
+func toJson(v interface{}) string {
+	j, err := marshalSelectJSON(toJsonValue(v))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return string(j)
+}
+
+// Value that marshals to the same JSON as the proto messages in it would with protojson
+func toJsonValue(v interface{}) interface{} {
+	if m, ok := v.(proto.Message); ok {
+		j, err := protojson.MarshalOptions{UseProtoNames: true, AllowPartial: true}.Marshal(m)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return json.RawMessage(j)
+	}
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.Slice, reflect.Array:
+		if r.Type().Elem().Kind() == reflect.Uint8 { // bytes
+			return v
+		}
+		items := make([]interface{}, r.Len())
+		for i := range items {
+			items[i] = toJsonValue(r.Index(i).Interface())
+		}
+		return items
+	case reflect.Map:
+		if r.Type().Key().Kind() != reflect.String {
+			return v
+		}
+		m := map[string]interface{}{}
+		for _, k := range r.MapKeys() {
+			m[k.String()] = toJsonValue(r.MapIndex(k).Interface())
+		}
+		return m
+	}
+	return v
+}
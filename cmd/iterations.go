@@ -0,0 +1,173 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"math"
+	"time"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+)
+
+var otgIterations int        // How many times to run traffic
+var otgBetweenStr string     // Pause between iterations
+var otgBetween time.Duration // Parsed pause between iterations
+var otgReapply bool          // Re-apply configuration and restart protocols before every iteration
+
+// flowResult holds results of a flow at the end of one iteration
+type flowResult struct {
+	lossPct       float64
+	throughputBps float64
+	latencyNs     float64
+	hasLatency    bool
+}
+
+// otgAggregate is min/max/mean/stddev of a flow metric across iterations
+type otgAggregate struct {
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+}
+
+// otgFlowAggregates holds aggregates of a flow results across iterations
+type otgFlowAggregates struct {
+	Name          string        `json:"name"`
+	LossPct       otgAggregate  `json:"loss_pct"`
+	ThroughputBps otgAggregate  `json:"throughput_bps"`
+	LatencyNs     *otgAggregate `json:"latency_ns,omitempty"` // Only if latency was measured in every iteration
+}
+
+// Run traffic the requested number of times, and report per-flow aggregates across iterations
+func runIterations(api gosnappi.Api, config gosnappi.Config) (gosnappi.Api, gosnappi.Config) {
+	if otgIterations <= 1 {
+		return runTraffic(api, config)
+	}
+	a, _ := api.(*otgApi)
+	results := []map[string]flowResult{}
+	for i := 1; i <= otgIterations; i++ {
+		if otgConfigWatch.changed() {
+			log.Infof("Skipping %d remaining iterations", otgIterations-i+1)
+			return api, config
+		}
+		if i > 1 {
+			if otgBetween > 0 {
+				log.Infof("Pausing for %s before the next iteration...", otgBetween)
+				time.Sleep(otgBetween)
+			}
+			if otgReapply {
+				startProtocols(applyConfig(stopProtocols(api, config)))
+			}
+			clearStatistics(api) // every iteration is measured from zero
+		}
+		if a != nil {
+			a.mu.Lock()
+			a.iteration = i
+			a.mu.Unlock()
+		}
+		log.Infof("Iteration %d of %d", i, otgIterations)
+		start := time.Now()
+		runTraffic(api, config)
+		results = append(results, collectFlowResults(api, time.Since(start)))
+	}
+	printIterationsSummary(api, config, results)
+	return api, config
+}
+
+// Get final flow metrics of an iteration. Throughput is averaged over the time traffic was running
+func collectFlowResults(api gosnappi.Api, duration time.Duration) map[string]flowResult {
+	req := gosnappi.NewMetricsRequest()
+	req.Flow()
+	mr, err := api.GetMetrics(req)
+	checkResponse(api, mr, err)
+
+	results := map[string]flowResult{}
+	for _, f := range mr.FlowMetrics().Items() {
+		r := flowResult{lossPct: flowLossPct(f)}
+		if duration > 0 && f.HasBytesRx() {
+			r.throughputBps = float64(f.BytesRx()) * 8 / duration.Seconds()
+		}
+		if f.HasLatency() && f.Latency().HasAverageNs() {
+			r.latencyNs = f.Latency().AverageNs()
+			r.hasLatency = true
+		}
+		results[f.Name()] = r
+	}
+	return results
+}
+
+// Calculate min/max/mean/stddev of values
+func aggregate(values []float64) otgAggregate {
+	if len(values) == 0 {
+		return otgAggregate{}
+	}
+	agg := otgAggregate{Min: values[0], Max: values[0]}
+	sum := 0.0
+	for _, v := range values {
+		agg.Min = math.Min(agg.Min, v)
+		agg.Max = math.Max(agg.Max, v)
+		sum += v
+	}
+	agg.Mean = sum / float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - agg.Mean) * (v - agg.Mean)
+	}
+	agg.Stddev = math.Sqrt(variance / float64(len(values)))
+	return agg
+}
+
+// Print per-flow aggregates across iterations as an iterations_summary record. It is printed with or without --events,
+// as it is the result of the run
+func printIterationsSummary(api gosnappi.Api, config gosnappi.Config, results []map[string]flowResult) {
+	e := newEvent(EVENT_ITERATIONS_SUMMARY)
+	e.Labels = streamLabels(api)
+	delete(e.Labels, LABEL_ITERATION)
+	if len(e.Labels) == 0 {
+		e.Labels = nil
+	}
+	e.Iterations = len(results)
+	for _, f := range config.Flows().Items() {
+		loss, tput, latency := []float64{}, []float64{}, []float64{}
+		for _, r := range results {
+			fr, ok := r[f.Name()]
+			if !ok {
+				continue
+			}
+			loss = append(loss, fr.lossPct)
+			tput = append(tput, fr.throughputBps)
+			if fr.hasLatency {
+				latency = append(latency, fr.latencyNs)
+			}
+		}
+		fa := otgFlowAggregates{Name: f.Name(), LossPct: aggregate(loss), ThroughputBps: aggregate(tput)}
+		if len(latency) > 0 && len(latency) == len(loss) {
+			l := aggregate(latency)
+			fa.LatencyNs = &l
+		}
+		e.Flows = append(e.Flows, fa)
+		log.Infof("Flow %s across %d iterations: loss %.3f%% (min %.3f, max %.3f, stddev %.3f), throughput %.0f bps (min %.0f, max %.0f, stddev %.0f)",
+			f.Name(), len(loss), fa.LossPct.Mean, fa.LossPct.Min, fa.LossPct.Max, fa.LossPct.Stddev,
+			fa.ThroughputBps.Mean, fa.ThroughputBps.Min, fa.ThroughputBps.Max, fa.ThroughputBps.Stddev)
+	}
+	printEventRecord(e)
+}
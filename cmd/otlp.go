@@ -0,0 +1,463 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	OTLP_GRPC                = "grpc"                     // OTLP over gRPC
+	OTLP_HTTP                = "http"                     // OTLP over HTTP, with protobuf payloads
+	OTLP_HTTP_PATH           = "/v1/metrics"              // Default path of OTLP/HTTP metrics
+	OTLP_RESOURCE_ATTRIBUTES = "OTEL_RESOURCE_ATTRIBUTES" // Standard ENV variable with resource attributes
+	OTLP_TIMEOUT             = 10 * time.Second           // Timeout of an export request
+	OTLP_SCOPE               = "github.com/open-traffic-generator/otgen"
+)
+
+// Resource attributes set by otgen
+const (
+	OTLP_ATTR_SERVICE_NAME = "service.name"
+	OTLP_ATTR_API_URL      = "otg.api.url"
+	OTLP_ATTR_CONFIG_NAME  = "otg.config.name"
+	OTLP_ATTR_RUN_ID       = "otg.run.id"
+)
+
+var otlpEndpoint string           // Address of the collector
+var otlpProtocol string           // OTLP_GRPC or OTLP_HTTP
+var otlpPushIntervalStr string    // Interval to push metrics to the collector
+var otlpConfigName string         // Name of the OTG configuration, as a resource attribute
+var otlpRunID string              // ID of the run, as a resource attribute
+var otlpResourceAttributes string // More resource attributes, as a comma-separated list of key=value
+
+// exportOtlpCmd represents the export otlp command
+var exportOtlpCmd = &cobra.Command{
+	Use:   "otlp",
+	Short: "Push OTG metrics to an OpenTelemetry collector",
+	Long: `
+Push port, flow and protocol metrics to an OpenTelemetry collector over OTLP,
+using gRPC or HTTP. Metrics are read from stdin, as printed by "otgen run", or
+polled from an OTG API endpoint with --api. The latest values are pushed on
+every --push-interval, and once more when the metrics stream on stdin ends.
+
+Metric names are made of the metrics type and the field, like otg.port.frames_tx,
+with the name of a port, flow or peer as an attribute. Frame, byte and message
+counts are cumulative sums, rates, loss and latency are gauges, and states like
+link or session_state are gauges of 1 for the current state and 0 for the others.
+
+Every OTG API endpoint is a resource, with the otg.api.url, otg.config.name and
+otg.run.id attributes.
+
+For more information, go to https://github.com/open-traffic-generator/otgen
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pushInterval, err := time.ParseDuration(otlpPushIntervalStr)
+		if err != nil {
+			log.Fatalf("Incorrect format for --push-interval: %s", err)
+		}
+		e := newOtlpExporter()
+		state := newExportState()
+		done := make(chan bool)
+		go func() {
+			state.collect()
+			done <- true
+		}()
+		log.Infof("Pushing metrics to %s over OTLP/%s every %s", otlpEndpoint, strings.ToUpper(otlpProtocol), pushInterval)
+		ticker := time.NewTicker(pushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.push(state.latest())
+			case <-done:
+				e.push(state.latest())
+				return
+			}
+		}
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		setLogLevel(cmd, logLevel)
+		parseExportSourceFlags()
+		switch otlpProtocol {
+		case OTLP_GRPC, OTLP_HTTP:
+		default:
+			log.Fatalf("Unsupported OTLP protocol requested: %s", otlpProtocol)
+		}
+		if otlpRunID == "" {
+			otlpRunID = newRunID()
+		}
+		if _, err := parseResourceAttributes(otlpResourceAttributes); err != nil {
+			log.Fatalf("Incorrect format for --resource-attributes: %s", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	exportCmd.AddCommand(exportOtlpCmd)
+
+	exportOtlpCmd.Flags().StringVarP(&otlpEndpoint, "endpoint", "e", "localhost:4317", "Address of the OpenTelemetry collector, as host:port, or as a URL like https://collector:4318/v1/metrics")
+	exportOtlpCmd.Flags().StringVarP(&otlpProtocol, "protocol", "", OTLP_GRPC, fmt.Sprintf("OTLP transport:\n  \"%s\" for gRPC\n  \"%s\" for HTTP with protobuf payloads\n", OTLP_GRPC, OTLP_HTTP))
+	exportOtlpCmd.Flags().StringVarP(&otlpPushIntervalStr, "push-interval", "", "10s", "Interval to push metrics to the collector. Valid time units are 'ms', 's', 'm', 'h'")
+	exportOtlpCmd.Flags().StringVarP(&otlpConfigName, "config-name", "", "", "Name of the OTG configuration, for the otg.config.name resource attribute")
+	exportOtlpCmd.Flags().StringVarP(&otlpRunID, "run-id", "", "", "ID of the run, for the otg.run.id resource attribute. A random one is generated if not provided")
+	exportOtlpCmd.Flags().StringVarP(&otlpResourceAttributes, "resource-attributes", "", os.Getenv(OTLP_RESOURCE_ATTRIBUTES), "More resource attributes, as a comma-separated list of key=value. Overrides ENV:OTEL_RESOURCE_ATTRIBUTES")
+	addExportSourceFlags(exportOtlpCmd)
+}
+
+// Random ID for a run, in the form of a UUID version 4
+func newRunID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// Parse resource attributes like "deployment.environment=lab,host.name=tgen1"
+func parseResourceAttributes(s string) (map[string]string, error) {
+	attrs := map[string]string{}
+	for _, kv := range parseNameList(s) {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("%s is not a key=value pair", kv)
+		}
+		attrs[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return attrs, nil
+}
+
+// otlpExporter converts samples to OTLP metrics, and pushes them to the collector
+type otlpExporter struct {
+	send   func(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error
+	starts map[string]otlpStart // Start of every cumulative sum, by metric name and attributes
+	mu     sync.Mutex
+}
+
+// otlpStart is where a cumulative sum started from. A sum starts over when the counter goes down, like when traffic
+// is restarted
+type otlpStart struct {
+	time  time.Time
+	value float64
+}
+
+func newOtlpExporter() *otlpExporter {
+	e := &otlpExporter{starts: map[string]otlpStart{}}
+	switch otlpProtocol {
+	case OTLP_GRPC:
+		e.send = otlpGrpcSender(otlpEndpoint)
+	case OTLP_HTTP:
+		e.send = otlpHttpSender(otlpEndpoint)
+	}
+	return e
+}
+
+// Sender of OTLP/gRPC requests. TLS is used for https:// endpoints
+func otlpGrpcSender(endpoint string) func(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	creds := insecure.NewCredentials()
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		if u.Scheme == "https" {
+			creds = credentials.NewTLS(&tls.Config{})
+		}
+		endpoint = u.Host
+	}
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		log.Fatalf("Incorrect OTLP endpoint %s: %s", endpoint, err)
+	}
+	client := colmetricspb.NewMetricsServiceClient(conn)
+	return func(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+		res, err := client.Export(ctx, req)
+		if err != nil {
+			return err
+		}
+		if p := res.GetPartialSuccess(); p != nil && p.GetRejectedDataPoints() > 0 {
+			log.Warnf("Collector rejected %d data points: %s", p.GetRejectedDataPoints(), p.GetErrorMessage())
+		}
+		return nil
+	}
+}
+
+// Sender of OTLP/HTTP requests. Endpoints without a scheme use plain HTTP, and endpoints without a path use the
+// default path for metrics
+func otlpHttpSender(endpoint string) func(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		log.Fatalf("Incorrect OTLP endpoint %s", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = OTLP_HTTP_PATH
+	}
+	location := u.String()
+	return func(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+		body, err := proto.Marshal(req)
+		if err != nil {
+			return err
+		}
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, location, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		r.Header.Set("Content-Type", "application/x-protobuf")
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		if res.StatusCode/100 != 2 {
+			return fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+		}
+		return nil
+	}
+}
+
+// Push the samples to the collector. Failures are reported, and the next push is attempted as usual
+func (e *otlpExporter) push(samples []exportSample) {
+	if len(samples) == 0 {
+		log.Debug("No metrics to push yet")
+		return
+	}
+	req := e.request(samples)
+	ctx, cancel := context.WithTimeout(context.Background(), OTLP_TIMEOUT)
+	defer cancel()
+	if err := e.send(ctx, req); err != nil {
+		log.Errorf("Failed to push metrics to %s: %s", otlpEndpoint, err)
+		return
+	}
+	log.Debugf("Pushed metrics of %d resources to %s", len(req.ResourceMetrics), otlpEndpoint)
+}
+
+// Build an export request with a resource for every OTG API endpoint the samples came from
+func (e *otlpExporter) request(samples []exportSample) *colmetricspb.ExportMetricsServiceRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	byEndpoint := map[string][]exportSample{}
+	endpoints := []string{}
+	for _, s := range samples {
+		ep := s.labels[LABEL_ENDPOINT]
+		if ep == "" {
+			ep = exportApiURL
+		}
+		if _, ok := byEndpoint[ep]; !ok {
+			endpoints = append(endpoints, ep)
+		}
+		byEndpoint[ep] = append(byEndpoint[ep], s)
+	}
+	req := &colmetricspb.ExportMetricsServiceRequest{}
+	for _, ep := range endpoints {
+		metrics := map[string]*metricspb.Metric{}
+		names := []string{}
+		for _, s := range byEndpoint[ep] {
+			for _, p := range s.points() {
+				for _, m := range e.metrics(p, s.timestamp) {
+					if prev, ok := metrics[m.Name]; ok {
+						appendDataPoints(prev, m)
+						continue
+					}
+					metrics[m.Name] = m
+					names = append(names, m.Name)
+				}
+			}
+		}
+		sort.Strings(names)
+		sm := &metricspb.ScopeMetrics{Scope: &commonpb.InstrumentationScope{Name: OTLP_SCOPE, Version: version}}
+		for _, n := range names {
+			sm.Metrics = append(sm.Metrics, metrics[n])
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, &metricspb.ResourceMetrics{
+			Resource:     otlpResource(ep),
+			ScopeMetrics: []*metricspb.ScopeMetrics{sm},
+		})
+	}
+	return req
+}
+
+// Resource of the OTG API endpoint, with attributes from flags
+func otlpResource(endpoint string) *resourcepb.Resource {
+	attrs := map[string]string{OTLP_ATTR_SERVICE_NAME: "otgen", OTLP_ATTR_RUN_ID: otlpRunID}
+	if endpoint != "" {
+		attrs[OTLP_ATTR_API_URL] = endpoint
+	}
+	if otlpConfigName != "" {
+		attrs[OTLP_ATTR_CONFIG_NAME] = otlpConfigName
+	}
+	more, _ := parseResourceAttributes(otlpResourceAttributes) // validated by PreRunE
+	for k, v := range more {
+		attrs[k] = v
+	}
+	return &resourcepb.Resource{Attributes: otlpAttributes(attrs)}
+}
+
+// OTLP metrics for a point. A state set becomes one data point per state, and strings of an info point become
+// attributes of a gauge of 1
+func (e *otlpExporter) metrics(p exportPoint, timestamp time.Time) []*metricspb.Metric {
+	name := LINE_PREFIX + "." + p.metric
+	if p.field != "" {
+		name += "." + p.field
+	}
+	ts := uint64(timestamp.UnixNano())
+	switch p.kind {
+	case POINT_COUNTER:
+		key := name + formatLabels(p.labels, nil)
+		start, ok := e.starts[key]
+		if !ok || p.value < start.value {
+			start = otlpStart{timestamp, p.value}
+		}
+		start.value = p.value
+		e.starts[key] = start
+		return []*metricspb.Metric{{
+			Name: name,
+			Unit: otlpUnit(p.field),
+			Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+				DataPoints: []*metricspb.NumberDataPoint{{
+					Attributes:        otlpAttributes(p.labels),
+					StartTimeUnixNano: uint64(start.time.UnixNano()),
+					TimeUnixNano:      ts,
+					Value:             &metricspb.NumberDataPoint_AsInt{AsInt: int64(p.value)},
+				}},
+			}},
+		}}
+	case POINT_GAUGE:
+		return []*metricspb.Metric{otlpGauge(name, otlpUnit(p.field), &metricspb.NumberDataPoint{
+			Attributes:   otlpAttributes(p.labels),
+			TimeUnixNano: ts,
+			Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: p.value},
+		})}
+	case POINT_STATESET:
+		dps := []*metricspb.NumberDataPoint{}
+		for _, s := range p.states {
+			v := int64(0)
+			if s == p.state {
+				v = 1
+			}
+			attrs := map[string]string{"state": s}
+			for k, l := range p.labels {
+				attrs[k] = l
+			}
+			dps = append(dps, &metricspb.NumberDataPoint{
+				Attributes:   otlpAttributes(attrs),
+				TimeUnixNano: ts,
+				Value:        &metricspb.NumberDataPoint_AsInt{AsInt: v},
+			})
+		}
+		return []*metricspb.Metric{otlpGauge(name, "", dps...)}
+	case POINT_INFO:
+		attrs := map[string]string{}
+		for _, m := range []map[string]string{p.labels, p.info} {
+			for k, v := range m {
+				attrs[k] = v
+			}
+		}
+		return []*metricspb.Metric{otlpGauge(name+".info", "", &metricspb.NumberDataPoint{
+			Attributes:   otlpAttributes(attrs),
+			TimeUnixNano: ts,
+			Value:        &metricspb.NumberDataPoint_AsInt{AsInt: 1},
+		})}
+	}
+	return nil
+}
+
+func otlpGauge(name string, unit string, dps ...*metricspb.NumberDataPoint) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name: name,
+		Unit: unit,
+		Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: dps}},
+	}
+}
+
+// Add data points of a metric to another metric with the same name
+func appendDataPoints(to *metricspb.Metric, from *metricspb.Metric) {
+	switch d := to.Data.(type) {
+	case *metricspb.Metric_Sum:
+		d.Sum.DataPoints = append(d.Sum.DataPoints, from.GetSum().GetDataPoints()...)
+	case *metricspb.Metric_Gauge:
+		d.Gauge.DataPoints = append(d.Gauge.DataPoints, from.GetGauge().GetDataPoints()...)
+	}
+}
+
+// Attributes in the order of keys
+func otlpAttributes(m map[string]string) []*commonpb.KeyValue {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := []*commonpb.KeyValue{}
+	for _, k := range keys {
+		attrs = append(attrs, &commonpb.KeyValue{
+			Key:   k,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: m[k]}},
+		})
+	}
+	return attrs
+}
+
+// UCUM unit of a field, by its name
+func otlpUnit(field string) string {
+	switch {
+	case strings.HasSuffix(field, "_ns"):
+		return "ns"
+	case strings.HasSuffix(field, "_pct"):
+		return "%"
+	case strings.HasPrefix(field, "bytes_") && strings.HasSuffix(field, "_rate"):
+		return "By/s"
+	case strings.HasPrefix(field, "bytes_"):
+		return "By"
+	case strings.HasPrefix(field, "frames_") && strings.HasSuffix(field, "_rate"):
+		return "{frame}/s"
+	case strings.HasPrefix(field, "frames_"):
+		return "{frame}"
+	}
+	return ""
+}
@@ -0,0 +1,185 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	OTG_CONFIG_FILE = ".otgen.yaml"    // Name of the configuration file in the home directory
+	OTG_PROFILE     = "${OTG_PROFILE}" // Env var for the name of the profile to use
+)
+
+var cfgFile string     // Configuration file with named profiles
+var profileName string // Name of the profile to use from the configuration file
+
+// otgConfigFile is a configuration file with named profiles
+type otgConfigFile struct {
+	Profile  string                `yaml:"profile"`  // Profile to use when none was selected via --profile or ENV:OTG_PROFILE
+	Profiles map[string]otgProfile `yaml:"profiles"` // Named profiles
+}
+
+// otgProfile holds default values for a lab, each of them taking place of an OTG_* env variable
+type otgProfile struct {
+	Api        string            `yaml:"api"`         // OTG_API
+	Transport  string            `yaml:"transport"`   // OTG_TRANSPORT
+	Insecure   bool              `yaml:"insecure"`    // OTG_INSECURE
+	CACert     string            `yaml:"ca_cert"`     // OTG_CA_CERT
+	ClientCert string            `yaml:"client_cert"` // OTG_CLIENT_CERT
+	ClientKey  string            `yaml:"client_key"`  // OTG_CLIENT_KEY
+	Token      string            `yaml:"token"`       // OTG_API_TOKEN
+	Proxy      string            `yaml:"proxy"`       // OTG_PROXY
+	Locations  map[string]string `yaml:"locations"`   // OTG_LOCATION_<PORT NAME>, for any port name
+	Flow       map[string]string `yaml:"flow"`        // OTG_FLOW_<KEY>, like smac_p1 or src_ipv4
+	Metrics    string            `yaml:"metrics"`     // OTG_METRICS
+	Interval   string            `yaml:"interval"`    // OTG_INTERVAL
+}
+
+const (
+	ANNOTATION_PROFILE_ENV = "otgen_profile_env" // Flag annotation with the env variable and the default value the flag takes
+	ANNOTATION_PROFILE     = "otgen_profile"     // Command annotation for commands using values from the profile as they run
+)
+
+var profileVars map[string]string // Values from the selected profile, by the name of env variable they take place of
+
+// Value of the env variable from the selected profile. Empty until the profile is loaded, before the command runs
+func profileLookup(name string) string {
+	return profileVars[name]
+}
+
+// Let the default value of a flag come from the selected profile, same as from the env variable e, when the flag is
+// not set on the command line. d is the default value of the flag when neither of them has a value
+func profileFlag(cmd *cobra.Command, flag string, e string, d string) {
+	if err := cmd.Flags().SetAnnotation(flag, ANNOTATION_PROFILE_ENV, []string{e, d}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Mark a command as using values from the profile as it runs, like locations of ports
+func profileCommand(cmd *cobra.Command) {
+	if cmd.Annotations == nil {
+		cmd.Annotations = map[string]string{}
+	}
+	cmd.Annotations[ANNOTATION_PROFILE] = "true"
+}
+
+// Load the profile selected via --profile, ENV:OTG_PROFILE or in the configuration file, and use it for default values
+// of the flags of the command that were not set on the command line. Commands that don't use profiles don't load the
+// configuration file, so that it can't break them
+func applyProfile(cmd *cobra.Command) error {
+	uses := cmd.Annotations[ANNOTATION_PROFILE] == "true"
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		_, bound := f.Annotations[ANNOTATION_PROFILE_ENV]
+		uses = uses || bound
+	})
+	if !uses {
+		return nil
+	}
+	if err := loadProfile(cfgFile, profileName); err != nil {
+		return err
+	}
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		a, bound := f.Annotations[ANNOTATION_PROFILE_ENV]
+		if !bound || f.Changed || err != nil {
+			return
+		}
+		if e := f.Value.Set(envSubstOrDefault(a[0], a[1])); e != nil {
+			err = fmt.Errorf("incorrect default value of --%s: %s", f.Name, e)
+		}
+	})
+	return err
+}
+
+// Load the profile by name from the configuration file. The profile is selected via ENV:OTG_PROFILE, or in the
+// configuration file itself, if no name was given
+func loadProfile(file string, name string) error {
+	profileVars = map[string]string{}
+
+	if name == "" {
+		name = os.Getenv(strings.Trim(OTG_PROFILE, "${}"))
+	}
+	required := file != "" || name != "" // configuration file is optional, unless it or a profile was requested
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		file = filepath.Join(home, OTG_CONFIG_FILE)
+	}
+
+	b, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("can't read configuration file: %s", err)
+	}
+	var cfg otgConfigFile
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return fmt.Errorf("incorrect configuration file %s: %s", file, err)
+	}
+	if name == "" {
+		name = cfg.Profile
+	}
+	if name == "" {
+		return nil
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %s not found in %s", name, file)
+	}
+
+	set := func(name string, value string) {
+		if value != "" {
+			profileVars[name] = value
+		}
+	}
+	set("OTG_API", p.Api)
+	set("OTG_TRANSPORT", p.Transport)
+	if p.Insecure {
+		set("OTG_INSECURE", "true")
+	}
+	set("OTG_CA_CERT", p.CACert)
+	set("OTG_CLIENT_CERT", p.ClientCert)
+	set("OTG_CLIENT_KEY", p.ClientKey)
+	set("OTG_API_TOKEN", p.Token)
+	set("OTG_PROXY", p.Proxy)
+	for port, location := range p.Locations {
+		set("OTG_LOCATION_"+strings.ToUpper(port), location)
+	}
+	for k, v := range p.Flow {
+		set("OTG_FLOW_"+strings.ToUpper(k), v)
+	}
+	set("OTG_METRICS", p.Metrics)
+	set("OTG_INTERVAL", p.Interval)
+	return nil
+}
@@ -0,0 +1,166 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	PROMETHEUS_PREFIX       = "otg_" // Prefix of all the metric names
+	PROMETHEUS_CONTENT_TYPE = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+var prometheusListen string // Address to serve metrics on
+
+// exportPrometheusCmd represents the export prometheus command
+var exportPrometheusCmd = &cobra.Command{
+	Use:   "prometheus",
+	Short: "Serve OTG metrics for Prometheus to scrape",
+	Long: `
+Serve the latest port, flow and protocol metrics on /metrics in OpenMetrics text
+format, for Prometheus to scrape. Metrics are read from stdin, as printed by
+"otgen run", or polled from an OTG API endpoint with --api.
+
+Metric names are made of the metrics type and the field, like otg_port_frames_tx,
+with the name of a port, flow or peer as a label. Frame, byte and message counts
+are counters, rates, loss and latency are gauges, and states like link or
+session_state are state sets.
+
+For more information, go to https://github.com/open-traffic-generator/otgen
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		state := newExportState()
+		go state.collect()
+		http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", PROMETHEUS_CONTENT_TYPE)
+			writeOpenMetrics(w, state.latest())
+		})
+		log.Infof("Serving metrics on %s/metrics", prometheusListen)
+		if err := http.ListenAndServe(prometheusListen, nil); err != nil {
+			log.Fatal(err)
+		}
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		setLogLevel(cmd, logLevel)
+		parseExportSourceFlags()
+		return nil
+	},
+}
+
+func init() {
+	exportCmd.AddCommand(exportPrometheusCmd)
+
+	exportPrometheusCmd.Flags().StringVarP(&prometheusListen, "listen", "l", ":9100", "Address to serve metrics on, as host:port")
+	addExportSourceFlags(exportPrometheusCmd)
+}
+
+// Write the samples in OpenMetrics text format, one metric family at a time
+func writeOpenMetrics(w io.Writer, samples []exportSample) {
+	families := map[string][]exportPoint{}
+	for _, s := range samples {
+		for _, p := range s.points() {
+			families[p.name] = append(families[p.name], p)
+		}
+	}
+	names := []string{}
+	for n := range families {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, n := range names {
+		points := families[n]
+		name := PROMETHEUS_PREFIX + n
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, points[0].kind)
+		sort.SliceStable(points, func(i, j int) bool {
+			return formatLabels(points[i].labels, nil) < formatLabels(points[j].labels, nil)
+		})
+		lines := []string{}
+		for _, p := range points {
+			switch p.kind {
+			case POINT_COUNTER:
+				lines = append(lines, name+"_total"+formatLabels(p.labels, nil)+" "+formatValue(p.value))
+			case POINT_GAUGE:
+				lines = append(lines, name+formatLabels(p.labels, nil)+" "+formatValue(p.value))
+			case POINT_STATESET:
+				for _, s := range p.states {
+					v := "0"
+					if s == p.state {
+						v = "1"
+					}
+					lines = append(lines, name+formatLabels(p.labels, map[string]string{name: s})+" "+v)
+				}
+			case POINT_INFO:
+				lines = append(lines, name+"_info"+formatLabels(p.labels, p.info)+" 1")
+			}
+		}
+		for _, l := range lines {
+			b.WriteString(l + "\n")
+		}
+	}
+	b.WriteString("# EOF\n")
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		log.Debugf("Failed to serve metrics: %s", err)
+	}
+}
+
+// Format labels like {flow="f1",iteration="2"}, in the order of label names. Samples of a state set are told apart by
+// more labels
+func formatLabels(labels map[string]string, more map[string]string) string {
+	all := []string{}
+	for _, m := range []map[string]string{labels, more} {
+		for k, v := range m {
+			all = append(all, k+"=\""+escapeLabelValue(v)+"\"")
+		}
+	}
+	if len(all) == 0 {
+		return ""
+	}
+	sort.Strings(all)
+	return "{" + strings.Join(all, ",") + "}"
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,222 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	RAMP_STEP   = "step"   // Change the rate at every point of the ramp
+	RAMP_LINEAR = "linear" // Change the rate gradually from one point of the ramp to the next one
+)
+
+var otgRateProfileFile string   // File with a profile of flow rates over time
+var otgRateProfile *rateProfile // Parsed profile of flow rates, nil if not requested
+
+// rateProfile changes flow rates while traffic is running
+type rateProfile struct {
+	Ramps []rateRamp `yaml:"ramps"`
+}
+
+// rateRamp changes rates of a set of flows over time
+type rateRamp struct {
+	Flows    string      `yaml:"flows"`    // Flow names, as a comma-separated list. Glob patterns are supported (default all)
+	Mode     string      `yaml:"mode"`     // "step" or "linear" (default step)
+	Interval string      `yaml:"interval"` // How often to change the rate in linear mode (default 1s)
+	Points   []rampPoint `yaml:"points"`   // Rates at points in time since traffic was started
+
+	interval time.Duration
+}
+
+// rampPoint is the rate of the flows at a point in time since traffic was started
+type rampPoint struct {
+	At   string `yaml:"at"`
+	Rate string `yaml:"rate"`
+
+	at    time.Duration
+	value float64
+	unit  string
+}
+
+// Read and validate a profile of flow rates
+func loadRateProfile(file string) *rateProfile {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var p rateProfile
+	if err := yaml.UnmarshalStrict(b, &p); err != nil {
+		log.Fatalf("Incorrect rate profile %s: %s", file, err)
+	}
+	if len(p.Ramps) == 0 {
+		log.Fatalf("Incorrect rate profile %s: no ramps", file)
+	}
+	for i := range p.Ramps {
+		if err := p.Ramps[i].parse(); err != nil {
+			log.Fatalf("Incorrect rate profile %s, ramp %d: %s", file, i+1, err)
+		}
+	}
+	return &p
+}
+
+func (r *rateRamp) parse() error {
+	if r.Flows == "" {
+		r.Flows = "*"
+	}
+	switch r.Mode {
+	case "":
+		r.Mode = RAMP_STEP
+	case RAMP_STEP, RAMP_LINEAR:
+	default:
+		return fmt.Errorf("unsupported mode %s, has to be \"%s\" or \"%s\"", r.Mode, RAMP_STEP, RAMP_LINEAR)
+	}
+	r.interval = time.Second
+	if r.Interval != "" {
+		d, err := time.ParseDuration(r.Interval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("incorrect interval %s", r.Interval)
+		}
+		r.interval = d
+	}
+	if len(r.Points) == 0 {
+		return fmt.Errorf("no points")
+	}
+	for i := range r.Points {
+		p := &r.Points[i]
+		d, err := time.ParseDuration(p.At)
+		if err != nil {
+			return fmt.Errorf("incorrect time %s: %s", p.At, err)
+		}
+		if i > 0 && d <= r.Points[i-1].at {
+			return fmt.Errorf("points have to be in order of time, %s is not after %s", p.At, r.Points[i-1].At)
+		}
+		p.at = d
+		if p.value, p.unit, err = parseRate(p.Rate); err != nil {
+			return err
+		}
+		if r.Mode == RAMP_LINEAR && i > 0 && p.unit != r.Points[i-1].unit {
+			return fmt.Errorf("rates of a linear ramp have to be in the same units, %s and %s are not", r.Points[i-1].Rate, p.Rate)
+		}
+	}
+	return nil
+}
+
+// Rate of the ramp at the time since traffic was started. Returns an empty string before the first point
+func (r *rateRamp) rateAt(elapsed time.Duration) string {
+	k := -1
+	for i, p := range r.Points {
+		if p.at <= elapsed {
+			k = i
+		}
+	}
+	if k < 0 {
+		return ""
+	}
+	from := r.Points[k]
+	if r.Mode == RAMP_STEP || k == len(r.Points)-1 {
+		return from.Rate
+	}
+	to := r.Points[k+1]
+	t := (elapsed - from.at) / r.interval * r.interval // the rate changes once per interval
+	return formatRate(from.value+(to.value-from.value)*float64(t)/float64(to.at-from.at), from.unit)
+}
+
+func formatRate(v float64, unit string) string {
+	if unit == "%" {
+		return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64) + unit
+	}
+	return strconv.FormatFloat(math.Max(1, math.Round(v)), 'f', -1, 64) + unit
+}
+
+// rateProfileRun applies a rate profile to flows of a configuration on an endpoint
+type rateProfileRun struct {
+	api     gosnappi.Api
+	config  gosnappi.Config
+	flows   [][]string        // Flow names for every ramp
+	current map[string]string // Rates applied so far, by flow name
+}
+
+func newRateProfileRun(api gosnappi.Api, config gosnappi.Config) *rateProfileRun {
+	if otgRateProfile == nil {
+		return nil
+	}
+	run := &rateProfileRun{api: api, config: config, current: map[string]string{}}
+	for _, r := range otgRateProfile.Ramps {
+		run.flows = append(run.flows, matchNames("flow", parseNameList(r.Flows), configFlowNames(config)))
+	}
+	return run
+}
+
+// Apply the rates of the profile at the time since traffic was started, if they have changed
+func (run *rateProfileRun) apply(elapsed time.Duration) {
+	if run == nil {
+		return
+	}
+	rates := map[string]string{}
+	for i, r := range otgRateProfile.Ramps {
+		rate := r.rateAt(elapsed)
+		if rate == "" {
+			continue
+		}
+		for _, f := range run.flows[i] {
+			rates[f] = rate // a ramp that has started overrides ramps before it in the profile
+		}
+	}
+	changed := map[string]string{}
+	for f, rate := range rates {
+		if run.current[f] != rate {
+			changed[f] = rate
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	updateFlows(run.api, run.config, changed)
+	list := []string{}
+	for f, rate := range changed {
+		run.current[f] = rate
+		list = append(list, f+"="+rate)
+	}
+	sort.Strings(list)
+	log.Infof("Changed flow rates: %s", strings.Join(list, ", "))
+	e := newEvent(EVENT_RATE_CHANGED)
+	e.Rates = changed
+	printEvent(run.api, e)
+}
+
+// Set flow rates back to the configuration, once traffic is stopped
+func (run *rateProfileRun) restore() {
+	if run == nil || len(run.current) == 0 {
+		return
+	}
+	updateFlowRates(run.api, run.config, nil)
+}
@@ -0,0 +1,150 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var otgCombined bool // Print metrics polled on the same tick as one combined record
+
+const (
+	LABEL_ENDPOINT  = "endpoint"  // URL of the OTG API endpoint the metrics or the event came from
+	LABEL_ITERATION = "iteration" // Number of the iteration the metrics or the event belong to, starting from 1
+)
+
+// otgMetricsRecord combines MetricsResponses of different types polled on the same tick
+type otgMetricsRecord struct {
+	Timestamp string            `json:"timestamp"`        // Time of the tick, RFC3339 with nanoseconds
+	Labels    map[string]string `json:"labels,omitempty"` // Labels that apply to all the metrics in the record
+	Metrics   []json.RawMessage `json:"metrics"`          // MetricsResponses in the same format they are printed one per line
+}
+
+// Labels for metrics and events of the endpoint. Metrics with labels are always printed as records
+func streamLabels(api gosnappi.Api) map[string]string {
+	labels := map[string]string{}
+	if e := endpointLabel(api); e != "" {
+		labels[LABEL_ENDPOINT] = e
+	}
+	if a, ok := api.(*otgApi); ok && otgIterations > 1 {
+		a.mu.Lock()
+		if a.iteration > 0 {
+			labels[LABEL_ITERATION] = strconv.Itoa(a.iteration)
+		}
+		a.mu.Unlock()
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// print metrics polled on the same tick, either one per line, or as one combined record
+func printMetricsTick(api gosnappi.Api, tick time.Time, responses []gosnappi.MetricsResponse) {
+	labels := streamLabels(api)
+	if !otgCombined && labels == nil {
+		for _, mr := range responses {
+			if metricsReportable(mr) {
+				printMetricsResponseRawJson(mr)
+			}
+		}
+		return
+	}
+	record := otgMetricsRecord{
+		Timestamp: tick.Format(time.RFC3339Nano),
+		Labels:    labels,
+		Metrics:   []json.RawMessage{},
+	}
+	for _, mr := range responses {
+		if !metricsReportable(mr) {
+			continue
+		}
+		p, err := mr.Marshal().ToProto()
+		if err != nil {
+			log.Fatal(err)
+		}
+		j, err := otgMetricsResponseToJson(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		record.Metrics = append(record.Metrics, j)
+	}
+	if len(record.Metrics) == 0 {
+		return
+	}
+	j, err := json.Marshal(record)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(j))
+}
+
+// parse a line from the metrics stream as a combined metrics record. Returns nil if the line is not a combined record
+func parseMetricsRecord(text string) (*otgMetricsRecord, []gosnappi.MetricsResponse) {
+	var record otgMetricsRecord
+	if json.Unmarshal([]byte(text), &record) != nil || record.Metrics == nil {
+		return nil, nil
+	}
+	responses := []gosnappi.MetricsResponse{}
+	for _, m := range record.Metrics {
+		mr := gosnappi.NewMetricsResponse()
+		err := mr.Unmarshal().FromJson(string(m))
+		if err != nil {
+			log.Fatal(err)
+		}
+		responses = append(responses, mr)
+	}
+	return &record, responses
+}
+
+// copy of the MetricsResponse with a prefix added to the names of all the metric items, like ports, flows or protocol peers
+func prefixMetricNames(mr gosnappi.MetricsResponse, prefix string) gosnappi.MetricsResponse {
+	msg, err := mr.Marshal().ToProto()
+	if err != nil {
+		log.Fatal(err)
+	}
+	msg.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if !fd.IsList() || fd.Message() == nil {
+			return true
+		}
+		list := v.List()
+		for i := 0; i < list.Len(); i++ {
+			item := list.Get(i).Message()
+			nf := item.Descriptor().Fields().ByName("name")
+			if nf != nil && nf.Kind() == protoreflect.StringKind && item.Has(nf) {
+				item.Set(nf, protoreflect.ValueOfString(prefix+item.Get(nf).String()))
+			}
+		}
+		return true
+	})
+	prefixed := gosnappi.NewMetricsResponse()
+	if _, err := prefixed.Unmarshal().FromProto(msg); err != nil {
+		log.Fatal(err)
+	}
+	return prefixed
+}
@@ -0,0 +1,183 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	RETRY_MAX_BACKOFF = 10 * time.Second // Upper limit for a delay between two attempts of the same call
+)
+
+// retryPolicy controls how many times an API call failed with a transient error is retried, and how long to wait in between
+type retryPolicy struct {
+	retries int           // Number of retries after the first attempt
+	backoff time.Duration // Delay before the first retry, doubled for every next one
+}
+
+var otgRetries int                   // Number of retries for idempotent calls: GetMetrics, GetStates, GetConfig
+var otgRetryBackoffStr string        // Delay before the first retry of an idempotent call
+var otgControlRetries int            // Number of retries for control calls: SetConfig, SetControlState
+var otgControlRetryBackoffStr string // Delay before the first retry of a control call
+
+var otgReadRetry retryPolicy    // Parsed retry policy for idempotent calls: GetMetrics, GetStates, GetConfig
+var otgControlRetry retryPolicy // Parsed retry policy for control calls: SetConfig, SetControlState
+
+// Parse retry policies from --retries, --retry-backoff, --control-retries and --control-retry-backoff
+func parseRetryPolicies() {
+	backoff, err := time.ParseDuration(otgRetryBackoffStr)
+	if err != nil {
+		log.Fatalf("Incorrect format for --retry-backoff: %s", err)
+	}
+	controlBackoff, err := time.ParseDuration(otgControlRetryBackoffStr)
+	if err != nil {
+		log.Fatalf("Incorrect format for --control-retry-backoff: %s", err)
+	}
+	if otgRetries < 0 || otgControlRetries < 0 {
+		log.Fatal("Number of retries can't be negative")
+	}
+	otgReadRetry = retryPolicy{retries: otgRetries, backoff: backoff}
+	otgControlRetry = retryPolicy{retries: otgControlRetries, backoff: controlBackoff}
+	log.Debugf("Retry policy for idempotent calls: %d retries, %s backoff; for control calls: %d retries, %s backoff",
+		otgRetries, backoff, otgControlRetries, controlBackoff)
+}
+
+// delay before a retry, starting from 0 for the first one
+func (p retryPolicy) delay(retry int) time.Duration {
+	d := p.backoff
+	for i := 0; i < retry && d < RETRY_MAX_BACKOFF; i++ {
+		d *= 2
+	}
+	if d > RETRY_MAX_BACKOFF {
+		d = RETRY_MAX_BACKOFF
+	}
+	return d
+}
+
+// check if an API call failed for a reason that may go away if the call is repeated, like a network error or an overloaded endpoint
+func isTransientError(err error) bool {
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &netErr) || errors.As(err, &urlErr) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	otgErr, ok := gosnappi.FromError(err)
+	if !ok {
+		return false
+	}
+	switch otgErr.Code() {
+	case 500, 502, 503, 504: // HTTP transport
+		return true
+	case int32(codes.Unavailable), int32(codes.DeadlineExceeded), int32(codes.ResourceExhausted): // gRPC transport
+		return true
+	}
+	return false
+}
+
+// Make an API call within the deadline of the context, and retry it according to the policy if it fails
+// with a transient error. If the deadline of the run is hit, the run is terminated
+func (a *otgApi) retry(ctx context.Context, p retryPolicy, name string, handle gosnappi.Api, call func(gosnappi.Api) (interface{}, error)) (interface{}, error) {
+	res, err := a.withDeadline(ctx, name, handle, call)
+	for i := 0; i < p.retries && err != nil && ctx.Err() == nil && isTransientError(err); i++ {
+		d := p.delay(i)
+		log.Warnf("%s on %s failed: %v. Retrying in %s (%d of %d)...", name, a.location, err, d, i+1, p.retries)
+		if i == 0 {
+			a.mu.Lock()
+			a.retried++
+			a.mu.Unlock()
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+		}
+		res, err = a.withDeadline(ctx, name, handle, call)
+	}
+	if err != nil && ctx == a.ctx && ctx.Err() == context.DeadlineExceeded {
+		a.preempt(name)
+	}
+	return res, err
+}
+
+// GetMetrics is idempotent, and is retried according to --retries and --retry-backoff
+func (a *otgApi) GetMetrics(req gosnappi.MetricsRequest) (gosnappi.MetricsResponse, error) {
+	return a.getMetrics(a.Api, req)
+}
+
+// Get metrics from the endpoint using one of its handles
+func (a *otgApi) getMetrics(handle gosnappi.Api, req gosnappi.MetricsRequest) (gosnappi.MetricsResponse, error) {
+	res, err := a.retry(a.ctx, otgReadRetry, "GetMetrics", handle, func(h gosnappi.Api) (interface{}, error) {
+		return h.GetMetrics(req)
+	})
+	mr, _ := res.(gosnappi.MetricsResponse)
+	return a.subtractBaseline(mr), err
+}
+
+// GetStates is idempotent, and is retried according to --retries and --retry-backoff
+func (a *otgApi) GetStates(req gosnappi.StatesRequest) (gosnappi.StatesResponse, error) {
+	res, err := a.retry(a.ctx, otgReadRetry, "GetStates", a.Api, func(h gosnappi.Api) (interface{}, error) {
+		return h.GetStates(req)
+	})
+	sr, _ := res.(gosnappi.StatesResponse)
+	return sr, err
+}
+
+// GetConfig is idempotent, and is retried according to --retries and --retry-backoff
+func (a *otgApi) GetConfig() (gosnappi.Config, error) {
+	res, err := a.retry(a.ctx, otgReadRetry, "GetConfig", a.Api, func(h gosnappi.Api) (interface{}, error) {
+		return h.GetConfig()
+	})
+	config, _ := res.(gosnappi.Config)
+	return config, err
+}
+
+// SetConfig is retried according to --control-retries and --control-retry-backoff
+func (a *otgApi) SetConfig(config gosnappi.Config) (gosnappi.Warning, error) {
+	res, err := a.retry(a.ctx, otgControlRetry, "SetConfig", a.Api, func(h gosnappi.Api) (interface{}, error) {
+		return h.SetConfig(config)
+	})
+	w, _ := res.(gosnappi.Warning)
+	return w, err
+}
+
+// UpdateConfig is retried according to --control-retries and --control-retry-backoff
+func (a *otgApi) UpdateConfig(cu gosnappi.ConfigUpdate) (gosnappi.Warning, error) {
+	res, err := a.retry(a.ctx, otgControlRetry, "UpdateConfig", a.Api, func(h gosnappi.Api) (interface{}, error) {
+		return h.UpdateConfig(cu)
+	})
+	w, _ := res.(gosnappi.Warning)
+	return w, err
+}
+
+// Number of API calls retried on the endpoint so far
+func (a *otgApi) retriedCalls() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.retried
+}
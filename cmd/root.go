@@ -49,6 +49,9 @@ For more information, go to https://github.com/open-traffic-generator/otgen
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return applyProfile(cmd)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -65,7 +68,8 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.otgen.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "Configuration file with named profiles (default is $HOME/"+OTG_CONFIG_FILE+")")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Profile from the configuration file to use for default values. Overrides ENV:OTG_PROFILE")
 	rootCmd.PersistentFlags().StringVarP(&logLevel, "log", "", LOG_DEFAULT_LEVEL, "Logging level: err | warn | info | debug")
 
 	// Cobra also supports local flags, which will only run
@@ -27,6 +27,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/open-traffic-generator/snappi/gosnappi"
@@ -34,15 +35,18 @@ import (
 )
 
 const (
-	OTG_API         = "${OTG_API}"             // Env var for API endpoint
-	OTG_DEFAULT_API = "https://localhost:8443" // Default API endpoint value
+	OTG_API              = "${OTG_API}"             // Env var for API endpoint
+	OTG_DEFAULT_API      = "https://localhost:8443" // Default API endpoint value
+	OTG_METRICS          = "${OTG_METRICS}"         // Env var for metrics types to report
+	OTG_DEFAULT_METRICS  = "port"                   // Default metrics types to report
+	OTG_INTERVAL         = "${OTG_INTERVAL}"        // Env var for interval to pull metrics
+	OTG_DEFAULT_INTERVAL = "0.5s"                   // Default interval to pull metrics
 )
 
-var otgURL string                 // URL of OTG server API endpoint
-var otgIgnoreX509 bool            // Ignore X.509 certificate validation of OTG API endpoint
+var otgURLs []string              // URLs of OTG server API endpoints
 var otgYaml bool                  // Format of OTG input is YAML. Mutually exclusive with --json
 var otgJson bool                  // Format of OTG input is JSON. Mutually exclusive with --yaml
-var otgFile string                // OTG configuration file
+var otgFiles []string             // OTG configuration files, one for all the endpoints, or one per endpoint
 var otgRxBgpStr string            // How many BGP routes shall we receive to consider the protocol is up. In routes or multiples of routes advertised
 var otgRxBgpNumber uint64         // Parsed number of BGP routes we shall receive
 var otgRxBgpMultiplier int        // Parsed multiplier of advertised BGP routes we shall receive
@@ -55,6 +59,18 @@ var timeoutStr string             // Maximum total run time, including protocols
 var timeout time.Duration         // Parsed maximum total run time, including protocols convergence and running traffic
 var startTime time.Time           // Start time
 var protoMode string              // Protocols control mode
+var otgPortsStr string            // Port names or glob patterns to report metrics for, as a comma-separated list
+var otgPortNames []string         // Parsed port names or glob patterns
+var otgFlowsStr string            // Flow names or glob patterns to report metrics for, as a comma-separated list
+var otgFlowNames []string         // Parsed flow names or glob patterns
+var otgBgp4PeersStr string        // BGPv4 peer names or glob patterns to report metrics for, as a comma-separated list
+var otgBgp4PeerNames []string     // Parsed BGPv4 peer names or glob patterns
+var otgPortColumnsStr string      // PortMetrics columns to report, as a comma-separated list
+var otgPortColumns []string       // Parsed PortMetrics columns
+var otgFlowColumnsStr string      // FlowMetrics columns to report, as a comma-separated list
+var otgFlowColumns []string       // Parsed FlowMetrics columns
+var otgBgp4ColumnsStr string      // Bgpv4Metrics columns to report, as a comma-separated list
+var otgBgp4Columns []string       // Parsed Bgpv4Metrics columns
 
 // runCmd represents the run command
 var runCmd = &cobra.Command{
@@ -63,15 +79,44 @@ var runCmd = &cobra.Command{
 	Long: `
 Requests OTG API endpoint to apply OTG configuration and run Traffic Flows.
 
+With multiple --api endpoints, the same test runs against all of them in
+parallel. Metrics and event records are labelled with the endpoint they came
+from. If the test fails on any of the endpoints, traffic and protocols are
+stopped on all of them.
+
 For more information, go to https://github.com/open-traffic-generator/otgen
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		startTime = time.Now()
-		stopProtocols(runTraffic(startProtocols(applyConfig(initOTG()))))
+		log.ExitFunc = otgExit // stop traffic and protocols on all the endpoints on a fatal error
+		startRunDeadline(startTime, timeout)
+		defer stopRunDeadline()
+		apis, configs := initOTGs()
+		if otgWatch {
+			watchConfig(apis[0], configs[0])
+			return
+		}
+		if len(apis) == 1 {
+			stopProtocols(runIterations(warmUp(startProtocols(applyConfig(apis[0], configs[0])))))
+			printRunSummary()
+			return
+		}
+		// every stage has to complete on all the endpoints before the next one starts
+		runParallel(apis, configs, applyConfig)
+		runParallel(apis, configs, startProtocols)
+		runParallel(apis, configs, warmUp)
+		runParallel(apis, configs, runIterations)
+		runParallel(apis, configs, stopProtocols)
+		printRunSummary()
 	},
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		setLogLevel(cmd, logLevel)
 
+		// Configuration files for the endpoints
+		if len(otgFiles) > 1 && len(otgFiles) != len(otgURLs) {
+			log.Fatalf("Number of --file parameters (%d) has to be either 1, or match the number of --api endpoints (%d)", len(otgFiles), len(otgURLs))
+		}
+
 		// Number of routes to receive to consider BGP is up
 		if len(otgRxBgpStr) > 1 && strings.HasSuffix(otgRxBgpStr, "x") {
 			s := otgRxBgpStr[0 : len(otgRxBgpStr)-1]
@@ -106,6 +151,20 @@ For more information, go to https://github.com/open-traffic-generator/otgen
 		}
 		log.Debug("Will print these metrics: ", otgMetricsMap)
 
+		// Names and columns of metrics to report
+		otgPortNames = parseNameList(otgPortsStr)
+		otgFlowNames = parseNameList(otgFlowsStr)
+		otgBgp4PeerNames = parseNameList(otgBgp4PeersStr)
+		otgPortColumns = parseColumnList("port-columns", otgPortColumnsStr, otgPortColumnNames)
+		otgFlowColumns = parseColumnList("flow-columns", otgFlowColumnsStr, otgFlowColumnNames)
+		otgBgp4Columns = parseColumnList("bgp-columns", otgBgp4ColumnsStr, otgBgp4ColumnNames)
+
+		// API transport, TLS and authentication
+		parseApiFlags()
+
+		// Retry policies for API calls failed with transient errors
+		parseRetryPolicies()
+
 		// Metrics pull interval
 		var err error
 		otgPullInterval, err = time.ParseDuration(otgPullIntervalStr)
@@ -113,6 +172,42 @@ For more information, go to https://github.com/open-traffic-generator/otgen
 			log.Fatal(err)
 		}
 
+		// Warm-up before the measured run
+		if otgWarmupStr != "" {
+			otgWarmup, err = time.ParseDuration(otgWarmupStr)
+			if err != nil {
+				log.Fatalf("Incorrect format for --warmup: %s", err)
+			}
+			if err = setFlowRate(gosnappi.NewFlowRate(), otgWarmupRate); err != nil {
+				log.Fatalf("Incorrect --warmup-rate: %s", err)
+			}
+		}
+
+		// Conditions to stop traffic on
+		otgStopConditions = parseConditions("stop-on", otgStopOn)
+
+		// Flow rates to change while traffic is running
+		if otgRateProfileFile != "" {
+			otgRateProfile = loadRateProfile(otgRateProfileFile)
+		}
+
+		// Repeated iterations
+		if otgIterations < 1 {
+			log.Fatalf("Incorrect --iterations: %d, has to be 1 or more", otgIterations)
+		}
+		otgBetween, err = time.ParseDuration(otgBetweenStr)
+		if err != nil {
+			log.Fatalf("Incorrect format for --between: %s", err)
+		}
+
+		// Re-run when the configuration file changes
+		if otgWatch {
+			if len(otgFiles) != 1 || len(otgURLs) != 1 {
+				log.Fatal("--watch requires one --file and one --api endpoint")
+			}
+			otgConfigWatch = newConfigWatch(otgFiles[0])
+		}
+
 		// Maximum running time
 		if timeoutStr != "" {
 			timeout, err = time.ParseDuration(timeoutStr)
@@ -150,55 +245,95 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// runCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-	runCmd.Flags().StringVarP(&otgURL, "api", "a", envSubstOrDefault(OTG_API, OTG_DEFAULT_API), "URL of OTG API endpoint. Overrides ENV:OTG_API")
-	runCmd.Flags().BoolVarP(&otgIgnoreX509, "insecure", "k", false, "Ignore X.509 certificate validation of OTG API endpoint")
+	runCmd.Flags().StringArrayVarP(&otgURLs, "api", "a", []string{envSubstOrDefault(OTG_API, OTG_DEFAULT_API)}, "URL of OTG API endpoint. Overrides ENV:OTG_API. Repeat to run against multiple endpoints in parallel")
+	profileFlag(runCmd, "api", OTG_API, OTG_DEFAULT_API)
+	addApiFlags(runCmd)
+	runCmd.Flags().IntVarP(&otgRetries, "retries", "", 3, "How many times to retry idempotent API calls (GetMetrics, GetStates, GetConfig) failed with transient errors")
+	runCmd.Flags().StringVarP(&otgRetryBackoffStr, "retry-backoff", "", "250ms", "Delay before the first retry of an idempotent API call, doubled for every next retry. Valid time units are 'ms', 's', 'm', 'h'")
+	runCmd.Flags().IntVarP(&otgControlRetries, "control-retries", "", 0, "How many times to retry control API calls (SetConfig, SetControlState) failed with transient errors")
+	runCmd.Flags().StringVarP(&otgControlRetryBackoffStr, "control-retry-backoff", "", "1s", "Delay before the first retry of a control API call, doubled for every next retry. Valid time units are 'ms', 's', 'm', 'h'")
 	runCmd.Flags().BoolVarP(&otgYaml, "yaml", "y", false, "Format of OTG input is YAML. Mutually exclusive with --json. Assumed format by default")
 	runCmd.Flags().BoolVarP(&otgJson, "json", "j", false, "Format of OTG input is JSON. Mutually exclusive with --yaml")
 	runCmd.MarkFlagsMutuallyExclusive("json", "yaml")
-	runCmd.Flags().StringVarP(&otgFile, "file", "f", "", "OTG configuration file. If not provided, will use stdin")
+	runCmd.Flags().StringArrayVarP(&otgFiles, "file", "f", []string{}, "OTG configuration file. If not provided, will use stdin. Repeat to use a separate file for each --api endpoint, in the same order")
 	runCmd.Flags().StringVarP(&otgRxBgpStr, "rxbgp", "", "1x", "How many BGP routes shall we receive to consider the protocol is up. In routes or multiples of routes advertised")
-	runCmd.Flags().StringVarP(&otgMetrics, "metrics", "m", "port", "Metrics types to report as a comma-separated list:\n  \"port\" for PortMetrics,\n  \"flow\" for FlowMetrics,\n  \"bgp4\" for Bgpv4Metrics.\n  Example: bgp4,flow\n ")
-	runCmd.Flags().StringVarP(&otgPullIntervalStr, "interval", "i", "0.5s", "Interval to pull OTG metrics. Valid time units are 'ms', 's', 'm', 'h'. Example: 1s")
+	runCmd.Flags().StringVarP(&otgMetrics, "metrics", "m", envSubstOrDefault(OTG_METRICS, OTG_DEFAULT_METRICS), "Metrics types to report as a comma-separated list:\n  \"port\" for PortMetrics,\n  \"flow\" for FlowMetrics,\n  \"bgp4\" for Bgpv4Metrics.\n  Example: bgp4,flow\n ")
+	profileFlag(runCmd, "metrics", OTG_METRICS, OTG_DEFAULT_METRICS)
+	runCmd.Flags().StringVarP(&otgPortsStr, "ports", "", "", "Port names to report PortMetrics for, as a comma-separated list. Glob patterns are supported. Example: p1,p2 (default all)")
+	runCmd.Flags().StringVarP(&otgFlowsStr, "flows", "", "", "Flow names to report FlowMetrics for, as a comma-separated list. Glob patterns are supported. Example: f1,web-* (default all)")
+	runCmd.Flags().StringVarP(&otgBgp4PeersStr, "bgp-peers", "", "", "BGPv4 peer names to report Bgpv4Metrics for, as a comma-separated list. Glob patterns are supported. Example: otg1.bgp4.peer* (default all)")
+	runCmd.Flags().StringVarP(&otgPortColumnsStr, "port-columns", "", "", "PortMetrics columns to report, as a comma-separated list. Example: frames_tx,frames_rx (default all)")
+	runCmd.Flags().StringVarP(&otgFlowColumnsStr, "flow-columns", "", "", "FlowMetrics columns to report, as a comma-separated list. Example: transmit,frames_tx,frames_rx (default all)")
+	runCmd.Flags().StringVarP(&otgBgp4ColumnsStr, "bgp-columns", "", "", "Bgpv4Metrics columns to report, as a comma-separated list. Example: session_state,routes_received (default all)")
+	runCmd.Flags().StringVarP(&otgPullIntervalStr, "interval", "i", envSubstOrDefault(OTG_INTERVAL, OTG_DEFAULT_INTERVAL), "Interval to pull OTG metrics. Valid time units are 'ms', 's', 'm', 'h'. Example: 1s. Overrides ENV:OTG_INTERVAL")
+	profileFlag(runCmd, "interval", OTG_INTERVAL, OTG_DEFAULT_INTERVAL)
+	runCmd.Flags().BoolVarP(&otgCombined, "combined", "", false, "Print metrics of all requested types pulled at the same interval as one combined record")
+	runCmd.Flags().StringVarP(&otgWarmupStr, "warmup", "", "", "Transmit all flows at --warmup-rate for this long, then clear statistics before the measured run. Valid time units are 'ms', 's', 'm', 'h'. Example: 5s (default no warm-up)")
+	runCmd.Flags().StringVarP(&otgWarmupRate, "warmup-rate", "", "1%", "Rate to transmit flows at during warm-up, in % of line rate, pps, bps, kbps, mbps or gbps")
+	runCmd.Flags().StringVarP(&otgRateProfileFile, "rate-profile", "", "", "YAML file with step or linear ramps of flow rates to apply while traffic is running")
+	runCmd.Flags().StringArrayVarP(&otgStopOn, "stop-on", "", []string{}, "Abort the run as soon as a condition holds for metrics polled while traffic is running. Repeat for multiple conditions. Example: \"flow:*.loss_pct>5\" or \"bgp4:*.session_state!=up\"")
+	runCmd.Flags().IntVarP(&otgIterations, "iterations", "", 1, "How many times to run traffic. With more than 1, metrics are labelled with the iteration number, and per-flow aggregates across iterations are printed at the end")
+	runCmd.Flags().StringVarP(&otgBetweenStr, "between", "", "0s", "Pause between iterations. Valid time units are 'ms', 's', 'm', 'h'. Example: 10s")
+	runCmd.Flags().BoolVarP(&otgReapply, "reapply", "", false, "Re-apply OTG configuration and restart protocols before every iteration after the first one")
+	runCmd.Flags().BoolVarP(&otgWatch, "watch", "", false, "Keep running, and when the --file changes, stop traffic, re-apply the configuration and start protocols and traffic again. Stop with Ctrl-C")
 	runCmd.Flags().Float32VarP(&xeta, "xeta", "x", float32(0.0), "How long to wait before forcing traffic to stop. In multiples of ETA. Example: 1.5 (default is no limit)")
 	runCmd.Flags().StringVarP(&timeoutStr, "timeout", "", "", "Maximum total run time, including protocols convergence and running traffic. Valid time units are 'ms', 's', 'm', 'h'. Example: 2m (default unlimited)")
 	runCmd.Flags().StringVarP(&protoMode, "protocols", "", "auto", "Protocols control mode:\n  \"auto\" - detect, start and stop\n  \"ignore\" - do not detect, start or stop,\n  \"keep\" - detect, start but do not stop\n ")
+	runCmd.Flags().BoolVarP(&otgEvents, "events", "", false, "Print lifecycle event records to the metrics stream: config_applied, protocols_up, warmup_started, warmup_done, traffic_started, rate_changed, traffic_stopped, timeout, stop_condition, run_summary")
 }
 
-func initOTG() (gosnappi.Api, gosnappi.Config) {
-	var otgbytes []byte
-	var err error
-	if otgFile != "" { // Read OTG config from file
-		otgbytes, err = os.ReadFile(otgFile)
-		if err != nil {
-			log.Fatal(err)
+func initOTGs() ([]gosnappi.Api, []gosnappi.Config) {
+	var otgs []string
+	if len(otgFiles) > 0 { // Read OTG configs from files
+		for _, f := range otgFiles {
+			otgbytes, err := os.ReadFile(f)
+			if err != nil {
+				log.Fatal(err)
+			}
+			otgs = append(otgs, string(otgbytes))
 		}
 	} else { // Read OTG config from stdin
-		otgbytes, err = io.ReadAll(os.Stdin)
+		otgbytes, err := io.ReadAll(os.Stdin)
 		if err != nil {
 			log.Fatal(err)
 		}
+		otgs = append(otgs, string(otgbytes))
 	}
-	otg := string(otgbytes)
 
-	// Create a new API handle to make API calls against a traffic generator
-	api := gosnappi.NewApi()
+	apis := []gosnappi.Api{}
+	configs := []gosnappi.Config{}
+	for i, u := range otgURLs {
+		// Create a new API handle to make API calls against a traffic generator
+		apis = append(apis, newOtgApi(u))
 
-	// Set the transport protocol to either HTTP or GRPC
-	api.NewHttpTransport().SetLocation(otgURL).SetVerify(!otgIgnoreX509)
+		// Use the same configuration for all the endpoints, unless there is one per endpoint
+		otg := otgs[0]
+		if len(otgs) > 1 {
+			otg = otgs[i]
+		}
 
-	// Create a new traffic configuration that will be set on traffic generator
+		// Create a new traffic configuration that will be set on traffic generator
+		config, err := parseOTGConfig(otg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		configs = append(configs, config)
+	}
+
+	return apis, configs
+}
+
+// Parse OTG configuration in the format requested via --json or --yaml
+func parseOTGConfig(otg string) (gosnappi.Config, error) {
 	config := gosnappi.NewConfig()
+	var err error
 	// These are mutually exclusive parameters
 	if otgJson {
 		err = config.Unmarshal().FromJson(otg)
 	} else {
 		err = config.Unmarshal().FromYaml(otg) // Thus YAML is assumed by default, and as a superset of JSON, it actually works for JSON format too
 	}
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return api, config
+	return config, err
 }
 
 func applyConfig(api gosnappi.Api, config gosnappi.Config) (gosnappi.Api, gosnappi.Config) {
@@ -206,6 +341,7 @@ func applyConfig(api gosnappi.Api, config gosnappi.Config) (gosnappi.Api, gosnap
 	res, err := api.SetConfig(config)
 	checkResponse(api, res, err)
 	log.Info("ready.")
+	printEvent(api, newEvent(EVENT_CONFIG_APPLIED))
 	return api, config
 }
 
@@ -215,6 +351,7 @@ func startProtocols(api gosnappi.Api, config gosnappi.Config) (gosnappi.Api, gos
 	}
 	if len(config.Devices().Items()) > 0 { // TODO also if LAGs are configured
 		log.Info("Starting protocols...")
+		protocolsStart := time.Now()
 		ps := gosnappi.NewControlState()
 		ps.Protocol().All().SetState(gosnappi.StateProtocolAllState.START)
 		res, err := api.SetControlState(ps)
@@ -251,6 +388,27 @@ func startProtocols(api gosnappi.Api, config gosnappi.Config) (gosnappi.Api, gos
 
 		// Wait for configured protocols to come up
 		req := gosnappi.NewMetricsRequest()
+		if metricsFiltered("bgp4") {
+			// track the state of all the peers, but report only selected peers and columns
+			req.Bgpv4().SetColumnNames([]gosnappi.Bgpv4MetricsRequestColumnNamesEnum{
+				gosnappi.Bgpv4MetricsRequestColumnNames.SESSION_STATE,
+				gosnappi.Bgpv4MetricsRequestColumnNames.ROUTES_ADVERTISED,
+				gosnappi.Bgpv4MetricsRequestColumnNames.ROUTES_RECEIVED,
+			})
+		} else {
+			req.Bgpv4()
+		}
+		statePoll := &metricsPoll{req: req, report: true}
+		polls := []*metricsPoll{statePoll}
+		if metricsFiltered("bgp4") {
+			statePoll.report = false
+			if otgMetricsMap["bgp4"] {
+				polls = append(polls, &metricsPoll{req: newBgp4MetricsRequest(config), report: true})
+			}
+		}
+		ticker := time.NewTicker(otgPullInterval)
+		defer ticker.Stop()
+		protocolUpAt := make(map[string]time.Duration) // time every protocol first came up, for its convergence
 		for {
 			var protocolState = make(map[string]bool)
 			for p, c := range configuredProtocols {
@@ -260,9 +418,8 @@ func startProtocols(api gosnappi.Api, config gosnappi.Config) (gosnappi.Api, gos
 			}
 			proto := "bgp4"
 			if configuredProtocols[proto] && !protocolState[proto] {
-				req.Bgpv4()
-				res, err := api.GetMetrics(req)
-				printMetricsResponse(res, err)
+				pollMetrics(api, polls)
+				res := statePoll.res
 				protocolState[proto] = true
 				advertisedRoutes := uint64(0)
 				receivedRoutes := uint64(0)
@@ -296,20 +453,29 @@ func startProtocols(api gosnappi.Api, config gosnappi.Config) (gosnappi.Api, gos
 			waitIsOver := true
 			for p, s := range protocolState {
 				if s {
+					if _, ok := protocolUpAt[p]; !ok {
+						protocolUpAt[p] = time.Since(protocolsStart)
+					}
 					log.Infof("%s protocol is up.", strings.ToUpper(p))
 				} else {
 					waitIsOver = false
 				}
 			}
 			if waitIsOver {
+				for p := range protocolState {
+					e := newEvent(EVENT_PROTOCOLS_UP)
+					e.Protocol = p
+					e.ConvergenceMs = protocolUpAt[p].Milliseconds()
+					printEvent(api, e)
+				}
 				break
 			}
 			if timeout > 0 && timeout < time.Since(startTime) {
 				log.Errorf("Exceeded maximum time limit, terminating at startProtocols after %s", time.Since(startTime))
-				stopProtocols(api, config)
-				os.Exit(1)
+				printTimeoutEvent(api, "startProtocols")
+				otgExit(1)
 			}
-			time.Sleep(otgPullInterval)
+			<-ticker.C
 		}
 	}
 	return api, config
@@ -324,6 +490,7 @@ func runTraffic(api gosnappi.Api, config gosnappi.Config) (gosnappi.Api, gosnapp
 	res, err := api.SetControlState(ts)
 	checkResponse(api, res, err)
 	log.Info("started...")
+	printEvent(api, newEvent(EVENT_TRAFFIC_STARTED))
 
 	targetTx, trafficETA := calculateTrafficTargets(config)
 	log.Infof("Total packets to transmit: %d, ETA is: %s\n", targetTx, trafficETA)
@@ -349,27 +516,48 @@ func runTraffic(api gosnappi.Api, config gosnappi.Config) (gosnappi.Api, gosnapp
 		}
 	}
 
-	for trafficRunning() {
-		if otgMetricsMap["flow"] { // fetch flow metrics if requested
-			req.Flow()
-			metrics, err = api.GetMetrics(req)
-			printMetricsResponse(metrics, err)
-		}
-		if otgMetricsMap["port"] || !otgMetricsMap["flow"] { // fetch port metrics if requested, or if flow metrics are not being fetched
-			req.Port()
-			metrics, err = api.GetMetrics(req)
-			printMetricsResponse(metrics, err)
+	// poll all the requested metrics types on every tick, and use one of them to tell if traffic is still running
+	polls := []*metricsPoll{}
+	var statePoll *metricsPoll
+	if otgMetricsMap["flow"] { // fetch flow metrics if requested
+		statePoll = &metricsPoll{req: newFlowMetricsRequest(config), report: true}
+		polls = append(polls, statePoll)
+	}
+	if otgMetricsMap["port"] || !otgMetricsMap["flow"] { // fetch port metrics if requested, or if flow metrics are not being fetched
+		statePoll = &metricsPoll{req: newPortMetricsRequest(config), report: true}
+		polls = append(polls, statePoll)
+		if metricsFiltered("port") { // reported port metrics are not enough to tell if traffic is still running
+			statePoll = &metricsPoll{req: newTrafficStateRequest("port")}
+			polls = append(polls, statePoll)
 		}
+	} else if metricsFiltered("flow") { // reported flow metrics are not enough to tell if traffic is still running
+		statePoll = &metricsPoll{req: newTrafficStateRequest("flow")}
+		polls = append(polls, statePoll)
+	}
+	if otgMetricsMap["bgp4"] { // keep reporting protocol metrics while traffic is running
+		polls = append(polls, &metricsPoll{req: newBgp4MetricsRequest(config), report: true})
+	}
+	polls = addStopConditionPolls(polls)
+	profile := newRateProfileRun(api, config)
+
+	ticker := time.NewTicker(otgPullInterval)
+	defer ticker.Stop()
+	for trafficRunning() && !otgConfigWatch.changed() {
+		profile.apply(time.Since(start))
+		pollMetrics(api, polls)
+		checkStopConditions(api, polls)
+		metrics = statePoll.res
 		if timeout > 0 && timeout < time.Since(startTime) {
 			log.Errorf("Exceeded maximum time limit, terminating at runTraffic after %s", time.Since(startTime))
-			stopProtocols(stopTraffic(api, config))
-			os.Exit(1)
+			printTimeoutEvent(api, "runTraffic")
+			otgExit(1)
 		}
-		time.Sleep(otgPullInterval)
+		<-ticker.C
 	}
 
 	// stop transmitting traffic
 	stopTraffic(api, config)
+	profile.restore()
 	return api, config
 }
 
@@ -382,6 +570,7 @@ func stopTraffic(api gosnappi.Api, config gosnappi.Config) (gosnappi.Api, gosnap
 	res, err := api.SetControlState(ts)
 	checkResponse(api, res, err)
 	log.Info("stopped.")
+	printEvent(api, newEvent(EVENT_TRAFFIC_STOPPED))
 	return api, config
 }
 
@@ -515,10 +704,39 @@ func checkResponse(api gosnappi.Api, res interface{}, err error) {
 	}
 }
 
-func printMetricsResponse(mr gosnappi.MetricsResponse, err error) {
-	if err != nil {
-		log.Fatal(err)
+// metricsPoll is a metrics request sent on every tick, together with its latest response
+type metricsPoll struct {
+	req    gosnappi.MetricsRequest
+	report bool // print the response, if its metrics type was requested
+	res    gosnappi.MetricsResponse
+	err    error
+}
+
+// send all the metrics requests concurrently, each on a handle of its own, and print the responses to report
+func pollMetrics(api gosnappi.Api, polls []*metricsPoll) {
+	tick := time.Now()
+	var wg sync.WaitGroup
+	for i, p := range polls {
+		wg.Add(1)
+		go func(handle gosnappi.Api, p *metricsPoll) {
+			defer wg.Done()
+			p.res, p.err = handle.GetMetrics(p.req)
+		}(pollApi(api, i), p)
+	}
+	wg.Wait()
+
+	responses := []gosnappi.MetricsResponse{}
+	for _, p := range polls {
+		checkResponse(api, p.res, p.err)
+		if p.report {
+			responses = append(responses, p.res)
+		}
 	}
+	printMetricsTick(api, tick, responses)
+}
+
+// check if metrics type of the response was requested to be reported
+func metricsReportable(mr gosnappi.MetricsResponse) bool {
 	print := false
 	if mr.Choice() == "bgpv4_metrics" && otgMetricsMap["bgp4"] {
 		print = true
@@ -529,9 +747,7 @@ func printMetricsResponse(mr gosnappi.MetricsResponse, err error) {
 	} else if len(otgMetricsMap) == 0 {
 		print = true // print any metrics if no specific instructions were given
 	}
-	if print {
-		printMetricsResponseRawJson(mr)
-	}
+	return print
 }
 
 func printMetricsResponseRawJson(mr gosnappi.MetricsResponse) {
@@ -0,0 +1,489 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+)
+
+var transformSelect string // Selection expression, like "flow_metrics[*].{name,frames_rx,loss}"
+
+// Steps of a selection expression
+const (
+	SELECT_FIELD    = iota // .field
+	SELECT_INDEX           // [1], or [-1] for the last element
+	SELECT_WILDCARD        // [*], projects the rest of the expression on every element
+	SELECT_FILTER          // [?field==value], projects the rest of the expression on matching elements
+	SELECT_HASH            // {key: expression, ...}
+)
+
+// selectStep is one step of a selection expression
+type selectStep struct {
+	kind   int
+	field  string        // SELECT_FIELD
+	index  int           // SELECT_INDEX
+	filter selectFilter  // SELECT_FILTER
+	keys   []string      // SELECT_HASH
+	values []selectSteps // SELECT_HASH, expression for every key
+}
+
+// selectFilter compares a field of an element with a value, like "name=='f1'" or "frames_rx>0"
+type selectFilter struct {
+	path  selectSteps
+	op    string
+	value string
+}
+
+// selectSteps is a parsed selection expression, a subset of JMESPath
+type selectSteps []selectStep
+
+// selectObject is a JSON object that keeps its keys in order
+type selectObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newSelectObject() *selectObject {
+	return &selectObject{values: map[string]interface{}{}}
+}
+
+func (o *selectObject) set(k string, v interface{}) {
+	if _, ok := o.values[k]; !ok {
+		o.keys = append(o.keys, k)
+	}
+	o.values[k] = v
+}
+
+func (o *selectObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		kj, _ := marshalSelectJSON(k)
+		vj, err := marshalSelectJSON(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(kj)
+		b.WriteByte(':')
+		b.Write(vj)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Marshal JSON without escaping names like "p1->p2"
+func marshalSelectJSON(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// selectParser parses a selection expression one character at a time
+type selectParser struct {
+	s   string
+	pos int
+}
+
+// Parse a selection expression like "flow_metrics[*].{name,frames_rx,loss}", "port_metrics[?name=='p1'].frames_tx"
+// or "bgpv4_metrics[*].{peer: name, state: session_state}"
+func parseSelect(s string) (selectSteps, error) {
+	p := &selectParser{s: s}
+	steps, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos:])
+	}
+	return steps, nil
+}
+
+func (p *selectParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("at %d: %s", p.pos+1, fmt.Sprintf(format, a...))
+}
+
+func (p *selectParser) skipSpaces() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// Next character after spaces, or 0 at the end
+func (p *selectParser) peek() byte {
+	p.skipSpaces()
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *selectParser) identifier() string {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *selectParser) expression() (selectSteps, error) {
+	steps := selectSteps{}
+	for first := true; ; first = false {
+		switch c := p.peek(); {
+		case c == '[':
+			step, err := p.bracket()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+			continue
+		case c == '.' && !first:
+			p.pos++
+		case !first:
+			return steps, nil
+		}
+		if p.peek() == '{' {
+			step, err := p.hash()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+			continue
+		}
+		field := p.identifier()
+		if field == "" {
+			return nil, p.errorf("a field name is expected")
+		}
+		steps = append(steps, selectStep{kind: SELECT_FIELD, field: field})
+	}
+}
+
+func (p *selectParser) bracket() (selectStep, error) {
+	p.pos++ // [
+	var step selectStep
+	switch c := p.peek(); {
+	case c == '*':
+		p.pos++
+		step.kind = SELECT_WILDCARD
+	case c == '?':
+		p.pos++
+		path, err := p.expression()
+		if err != nil {
+			return step, err
+		}
+		p.skipSpaces()
+		op := ""
+		for _, o := range []string{"==", "!=", ">=", "<=", ">", "<"} {
+			if strings.HasPrefix(p.s[p.pos:], o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return step, p.errorf("a comparison like ==, !=, >, <, >= or <= is expected")
+		}
+		p.pos += len(op)
+		value, err := p.literal()
+		if err != nil {
+			return step, err
+		}
+		step.kind, step.filter = SELECT_FILTER, selectFilter{path: path, op: op, value: value}
+	default:
+		p.skipSpaces()
+		start := p.pos
+		for p.pos < len(p.s) && (p.s[p.pos] == '-' || p.s[p.pos] >= '0' && p.s[p.pos] <= '9') {
+			p.pos++
+		}
+		i, err := strconv.Atoi(p.s[start:p.pos])
+		if err != nil {
+			return step, p.errorf("*, ?filter or an index is expected in []")
+		}
+		step.kind, step.index = SELECT_INDEX, i
+	}
+	if p.peek() != ']' {
+		return step, p.errorf("] is expected")
+	}
+	p.pos++
+	return step, nil
+}
+
+// Parse a literal like 'f1', "f1", `5` or 5
+func (p *selectParser) literal() (string, error) {
+	p.skipSpaces()
+	if p.pos < len(p.s) {
+		if q := p.s[p.pos]; q == '\'' || q == '"' || q == '`' {
+			end := strings.IndexByte(p.s[p.pos+1:], q)
+			if end < 0 {
+				return "", p.errorf("closing %c is expected", q)
+			}
+			v := p.s[p.pos+1 : p.pos+1+end]
+			p.pos += end + 2
+			return v, nil
+		}
+	}
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" ]", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("a value to compare with is expected")
+	}
+	return p.s[start:p.pos], nil
+}
+
+// Parse {name, tx: frames_tx, latency.average_ns}. Keys of expressions without one are their fields joined with "_"
+func (p *selectParser) hash() (selectStep, error) {
+	p.pos++ // {
+	step := selectStep{kind: SELECT_HASH}
+	for {
+		start := p.pos
+		key := p.identifier()
+		if key != "" && p.peek() == ':' {
+			p.pos++
+		} else {
+			key, p.pos = "", start
+		}
+		value, err := p.expression()
+		if err != nil {
+			return step, err
+		}
+		if key == "" {
+			fields := []string{}
+			for _, s := range value {
+				if s.kind == SELECT_FIELD {
+					fields = append(fields, s.field)
+				}
+			}
+			key = strings.Join(fields, "_")
+		}
+		step.keys, step.values = append(step.keys, key), append(step.values, value)
+		switch p.peek() {
+		case ',':
+			p.pos++
+			continue
+		case '}':
+			p.pos++
+			return step, nil
+		}
+		return step, p.errorf(", or } is expected")
+	}
+}
+
+// Evaluate the expression on a JSON value. Missing fields are nil, and projections leave them out
+func (steps selectSteps) eval(v interface{}) interface{} {
+	for i, s := range steps {
+		if v == nil {
+			return nil
+		}
+		switch s.kind {
+		case SELECT_FIELD:
+			o, ok := v.(*selectObject)
+			if !ok {
+				return nil
+			}
+			v = o.values[s.field]
+		case SELECT_INDEX:
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			n := s.index
+			if n < 0 {
+				n += len(a)
+			}
+			if n < 0 || n >= len(a) {
+				return nil
+			}
+			v = a[n]
+		case SELECT_WILDCARD, SELECT_FILTER:
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			results := []interface{}{}
+			for _, e := range a {
+				if s.kind == SELECT_FILTER && !s.filter.match(e) {
+					continue
+				}
+				if r := steps[i+1:].eval(e); r != nil {
+					results = append(results, r)
+				}
+			}
+			return results
+		case SELECT_HASH:
+			o := newSelectObject()
+			for j, k := range s.keys {
+				o.set(k, s.values[j].eval(v))
+			}
+			v = o
+		}
+	}
+	return v
+}
+
+func (f selectFilter) match(v interface{}) bool {
+	actual := f.path.eval(v)
+	if actual == nil {
+		return false
+	}
+	text := fmt.Sprintf("%v", actual)
+	a, aerr := strconv.ParseFloat(text, 64)
+	b, berr := strconv.ParseFloat(f.value, 64)
+	if aerr == nil && berr == nil {
+		return compareNumbers(a, f.op, b)
+	}
+	switch f.op {
+	case "==":
+		return text == f.value
+	case "!=":
+		return text != f.value
+	}
+	return false
+}
+
+// Decode JSON keeping the order of keys, and numbers as they are
+func decodeSelectJSON(d *json.Decoder) (interface{}, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		o := newSelectObject()
+		for d.More() {
+			k, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeSelectJSON(d)
+			if err != nil {
+				return nil, err
+			}
+			o.set(k.(string), v)
+		}
+		_, err = d.Token()
+		return o, err
+	case json.Delim('['):
+		a := []interface{}{}
+		for d.More() {
+			v, err := decodeSelectJSON(d)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		_, err = d.Token()
+		return a, err
+	}
+	return t, nil
+}
+
+// Proto-named JSON of the MetricsResponse, with derived fields like loss_pct added to its items
+func selectMetricsJSON(mr gosnappi.MetricsResponse) interface{} {
+	msg, err := mr.Marshal().ToProto()
+	if err != nil {
+		log.Fatal(err)
+	}
+	j, err := otgMetricsResponseToJson(msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	v, err := decodeSelectJSON(d)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for kind, k := range conditionKinds {
+		if k.choice != string(mr.Choice()) {
+			continue
+		}
+		items, _ := v.(*selectObject).values[k.choice].([]interface{})
+		for i, item := range items {
+			for field, derive := range derivedFields[kind] {
+				item.(*selectObject).set(field, json.Number(strconv.FormatFloat(derive(mr, i), 'f', -1, 32)))
+			}
+		}
+	}
+	return v
+}
+
+// Flatten nested objects into their parent, with keys joined by "_", like latency_average_ns. Fields that are missing
+// are left out
+func flattenSelectObject(o *selectObject) *selectObject {
+	flat := newSelectObject()
+	var add func(prefix string, o *selectObject)
+	add = func(prefix string, o *selectObject) {
+		for _, k := range o.keys {
+			switch v := o.values[k].(type) {
+			case nil:
+			case *selectObject:
+				add(prefix+k+"_", v)
+			default:
+				flat.set(prefix+k, v)
+			}
+		}
+	}
+	add("", o)
+	return flat
+}
+
+// Apply the selection to the MetricsResponse, and print the result as one line. Objects are flattened, and printed as
+// an array even when one is selected, for display to read them. Nothing is printed when nothing matches, like for
+// other metrics types
+func selectMetricsResponse(steps selectSteps, mr gosnappi.MetricsResponse) {
+	result := steps.eval(selectMetricsJSON(mr))
+	switch r := result.(type) {
+	case nil:
+		return
+	case *selectObject:
+		result = []interface{}{flattenSelectObject(r)}
+	case []interface{}:
+		for i, e := range r {
+			if o, ok := e.(*selectObject); ok {
+				r[i] = flattenSelectObject(o)
+			}
+		}
+	}
+	j, err := marshalSelectJSON(result)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(j))
+}
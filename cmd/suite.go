@@ -0,0 +1,591 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/open-traffic-generator/snappi/gosnappi"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	SUITE_DEFAULT_CONFIGS = "otg.yml,otg.yaml,otg.json,*.otg.yml,*.otg.yaml,*.otg.json" // Default names of configuration files to discover
+
+	SUITE_PASSED  = "passed"
+	SUITE_FAILED  = "failed"  // An assertion did not hold
+	SUITE_ERROR   = "error"   // The run did not complete
+	SUITE_SKIPPED = "skipped" // Skipped via the sidecar file
+)
+
+var suiteApiURL string     // URL of OTG API endpoint to run the tests against
+var suiteConfigsStr string // Names of configuration files to discover, as a comma-separated list of glob patterns
+var suiteJUnitFile string  // File to write JUnit XML results to
+var suiteTimeout string    // Default time limit for every test
+var suiteFailFast bool     // Stop after the first test that did not pass
+
+// suiteCmd represents the suite command
+var suiteCmd = &cobra.Command{
+	Use:   "suite",
+	Short: "Run a suite of OTG configurations as tests",
+	Long: `
+Run a suite of OTG configurations as tests, and report the results.
+
+For more information, go to https://github.com/open-traffic-generator/otgen
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Error("You must specify a suite action, one of the following: run")
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		setLogLevel(cmd, logLevel)
+		return nil
+	},
+}
+
+// suiteRunCmd represents the suite run command
+var suiteRunCmd = &cobra.Command{
+	Use:   "run dir [dir...]",
+	Short: "Run all OTG configurations found in directories, one after another",
+	Long: `
+Run all OTG configurations found in directories, one after another, and print a
+summary of the results. Every configuration is run with "otgen run", and is a
+test that passes if the run completes and all its assertions hold.
+
+Configuration files are discovered recursively by name, see --configs. A test
+can have a sidecar file next to its configuration: "suite.yml" for "otg.yml",
+or "name.suite.yml" for "name.otg.yml". For example:
+
+  name: b2b-line-rate
+  args: ["--xeta", "2", "--rxbgp", "2x"]   # additional "otgen run" options
+  timeout: 2m                              # time limit for the test
+  assert:                                  # conditions on the final metrics
+    - flow:*.loss_pct<=0.1
+    - port:p2.frames_rx>=1000
+  skip: waiting for a fix of the DUT       # skip the test, with a reason
+
+An assertion holds if it holds for every item of matching name, and there is at
+least one such item. The syntax is the same as for "otgen run --stop-on".
+
+For more information, go to https://github.com/open-traffic-generator/otgen
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tests := discoverSuiteTests(args)
+		if len(tests) == 0 {
+			log.Fatalf("No OTG configuration files like %s found in %s", suiteConfigsStr, strings.Join(args, ", "))
+		}
+		runFlags := suiteRunFlags(cmd)
+		start := time.Now()
+		results := []suiteResult{}
+		for i, t := range tests {
+			log.Infof("Running test %d of %d: %s...", i+1, len(tests), t.Name)
+			r := t.run(runFlags)
+			log.Infof("Test %s %s in %s", t.Name, r.Status, r.Duration.Round(time.Millisecond))
+			results = append(results, r)
+			if suiteFailFast && r.Status != SUITE_PASSED && r.Status != SUITE_SKIPPED {
+				log.Warnf("Stopping after %s %s, %d tests not run", t.Name, r.Status, len(tests)-i-1)
+				break
+			}
+		}
+		duration := time.Since(start)
+		printSuiteSummary(results, duration)
+		if suiteJUnitFile != "" {
+			writeSuiteJUnit(suiteJUnitFile, results, start, duration)
+		}
+		for _, r := range results {
+			if r.Status == SUITE_FAILED || r.Status == SUITE_ERROR {
+				os.Exit(1)
+			}
+		}
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		setLogLevel(cmd, logLevel)
+		parseApiFlags()
+		for _, p := range parseNameList(suiteConfigsStr) {
+			if _, err := path.Match(p, ""); err != nil {
+				log.Fatalf("Incorrect --configs pattern %s: %s", p, err)
+			}
+		}
+		if suiteTimeout != "" {
+			if _, err := time.ParseDuration(suiteTimeout); err != nil {
+				log.Fatalf("Incorrect format for --timeout: %s", err)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(suiteCmd)
+	suiteCmd.AddCommand(suiteRunCmd)
+
+	suiteRunCmd.Flags().StringVarP(&suiteApiURL, "api", "a", envSubstOrDefault(OTG_API, OTG_DEFAULT_API), "URL of OTG API endpoint. Overrides ENV:OTG_API")
+	profileFlag(suiteRunCmd, "api", OTG_API, OTG_DEFAULT_API)
+	addApiFlags(suiteRunCmd)
+	suiteRunCmd.Flags().StringVarP(&suiteConfigsStr, "configs", "", SUITE_DEFAULT_CONFIGS, "Names of OTG configuration files to discover, as a comma-separated list of glob patterns")
+	suiteRunCmd.Flags().StringVarP(&suiteJUnitFile, "junit", "", "", "Write results of all the tests to a file in JUnit XML format")
+	suiteRunCmd.Flags().StringVarP(&suiteTimeout, "timeout", "", "", "Time limit for every test, unless set in its sidecar file. Valid time units are 'ms', 's', 'm', 'h'. Example: 5m (default unlimited)")
+	suiteRunCmd.Flags().BoolVarP(&suiteFailFast, "fail-fast", "", false, "Stop after the first test that fails")
+}
+
+// suiteTest is an OTG configuration to run as a test, with options from its sidecar file
+type suiteTest struct {
+	Name    string   `yaml:"name"`    // Name of the test (default the path of the configuration)
+	Args    []string `yaml:"args"`    // Additional options for "otgen run"
+	Timeout string   `yaml:"timeout"` // Time limit for the test
+	Assert  []string `yaml:"assert"`  // Conditions on the final metrics, like "flow:*.loss_pct<=0.1"
+	Skip    string   `yaml:"skip"`    // Reason to skip the test
+
+	file       string
+	conditions []metricCondition
+}
+
+// suiteResult is the outcome of a test
+type suiteResult struct {
+	Name     string
+	File     string
+	Status   string
+	Message  string // Why the test did not pass, or was skipped
+	Duration time.Duration
+	Metrics  suiteKeyMetrics
+	Stderr   string // Log of the run
+}
+
+// suiteKeyMetrics are totals over the final flow metrics, or port metrics if there were no flows
+type suiteKeyMetrics struct {
+	reported bool
+	FramesTx uint64
+	FramesRx uint64
+	LossPct  float64
+}
+
+// Find configuration files in the directories, in the order of their paths, and read their sidecar files
+func discoverSuiteTests(dirs []string) []*suiteTest {
+	patterns := parseNameList(suiteConfigsStr)
+	files := []string{}
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			for _, pattern := range patterns {
+				if ok, _ := path.Match(pattern, d.Name()); ok {
+					files = append(files, p)
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	sort.Strings(files)
+
+	tests := []*suiteTest{}
+	for _, f := range files {
+		t := &suiteTest{file: f}
+		if sidecar := suiteSidecarFile(f); sidecar != "" {
+			b, err := os.ReadFile(sidecar)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := yaml.UnmarshalStrict(b, t); err != nil {
+				log.Fatalf("Incorrect sidecar file %s: %s", sidecar, err)
+			}
+			for _, a := range t.Assert {
+				c, err := parseCondition(a)
+				if err != nil {
+					log.Fatalf("Incorrect assertion %s in %s: %s", a, sidecar, err)
+				}
+				t.conditions = append(t.conditions, c)
+			}
+			if t.Timeout != "" {
+				if _, err := time.ParseDuration(t.Timeout); err != nil {
+					log.Fatalf("Incorrect timeout %s in %s: %s", t.Timeout, sidecar, err)
+				}
+			}
+		}
+		if t.Name == "" {
+			t.Name = suiteTestName(f)
+		}
+		tests = append(tests, t)
+	}
+	return tests
+}
+
+// Sidecar file of a configuration file: "suite.yml" for "otg.yml", "name.suite.yml" for "name.otg.yml". Returns an
+// empty string if there is none
+func suiteSidecarFile(file string) string {
+	dir, base := filepath.Split(file)
+	prefix := ""
+	if i := strings.LastIndex(base, "otg."); i >= 0 {
+		prefix = base[:i]
+	}
+	for _, ext := range []string{".yml", ".yaml"} {
+		sidecar := filepath.Join(dir, prefix+"suite"+ext)
+		if _, err := os.Stat(sidecar); err == nil {
+			return sidecar
+		}
+	}
+	return ""
+}
+
+// Name of a test by its configuration file: the directory for "dir/otg.yml", "dir/name" for "dir/name.otg.yml"
+func suiteTestName(file string) string {
+	dir, base := filepath.Split(file)
+	dir = filepath.Clean(dir)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	if name == "otg" {
+		return dir
+	}
+	return filepath.Join(dir, strings.TrimSuffix(name, ".otg"))
+}
+
+// "otgen run" options set on the suite command that every test is run with: API endpoint, transport, logging and
+// the configuration profile
+func suiteRunFlags(cmd *cobra.Command) []string {
+	args := []string{"--api", suiteApiURL}
+	forward := func(f *pflag.Flag) {
+		if f.Name == "api" || runCmd.Flags().Lookup(f.Name) == nil && rootCmd.PersistentFlags().Lookup(f.Name) == nil {
+			return
+		}
+		if f.Name == "timeout" {
+			return // applies to every test, see suiteTest.run
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	}
+	cmd.Flags().Visit(forward)
+	return args
+}
+
+// Take --metrics out of "otgen run" arguments from a sidecar file. Returns the rest of the arguments, and the metrics
+// types they asked for
+func suiteMetricsArgs(args []string) ([]string, []string) {
+	rest, metrics := []string{}, []string{}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			return append(rest, args[i:]...), metrics
+		case a == "--metrics" || a == "-m":
+			if i+1 < len(args) {
+				i++
+				metrics = append(metrics, parseNameList(args[i])...)
+			}
+		case strings.HasPrefix(a, "--metrics="):
+			metrics = append(metrics, parseNameList(strings.TrimPrefix(a, "--metrics="))...)
+		case strings.HasPrefix(a, "-m"):
+			metrics = append(metrics, parseNameList(strings.TrimPrefix(strings.TrimPrefix(a, "-m"), "="))...)
+		default:
+			rest = append(rest, a)
+		}
+	}
+	return rest, metrics
+}
+
+// Run the test with "otgen run", and check its assertions on the final metrics
+func (t *suiteTest) run(runFlags []string) suiteResult {
+	r := suiteResult{Name: t.Name, File: t.file}
+	if t.Skip != "" {
+		r.Status, r.Message = SUITE_SKIPPED, t.Skip
+		return r
+	}
+
+	// metrics the summary and the assertions need, on top of the ones the sidecar file asked for
+	testArgs, testMetrics := suiteMetricsArgs(t.Args)
+	kinds := map[string]bool{"port": true, "flow": true}
+	for _, c := range t.conditions {
+		kinds[c.kind] = true
+	}
+	for _, m := range testMetrics {
+		kinds[m] = true
+	}
+	metrics := []string{}
+	for k := range kinds {
+		metrics = append(metrics, k)
+	}
+	sort.Strings(metrics)
+
+	args := append([]string{"run", "--file", t.file}, runFlags...)
+	args = append(args, testArgs...)
+	args = append(args, "--metrics", strings.Join(metrics, ","))
+	timeout := suiteTimeout
+	if t.Timeout != "" {
+		timeout = t.Timeout
+	}
+	if timeout != "" {
+		args = append(args, "--timeout", timeout)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	run := exec.Command(exe, args...)
+	run.Stdout, run.Stderr = &stdout, &stderr
+	log.Debugf("Running %s %s", exe, strings.Join(args, " "))
+	start := time.Now()
+	err = run.Run()
+	r.Duration = time.Since(start)
+	r.Stderr = stderr.String()
+
+	latest := latestMetrics(&stdout)
+	r.Metrics = suiteMetrics(latest)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			log.Fatal(err)
+		}
+		r.Status, r.Message = SUITE_ERROR, lastLogError(r.Stderr)
+		if r.Message == "" {
+			r.Message = fmt.Sprintf("otgen run exited with code %d", exitErr.ExitCode())
+		}
+		return r
+	}
+
+	failures := []string{}
+	for _, c := range t.conditions {
+		results := c.evaluate(latest[conditionKinds[c.kind].choice])
+		if len(results) == 0 {
+			failures = append(failures, fmt.Sprintf("%s: no %s metrics to check", c.text, c.kind))
+		}
+		for _, res := range results {
+			if !res.ok {
+				failures = append(failures, fmt.Sprintf("%s: %s", c.text, res.reason))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		r.Status, r.Message = SUITE_FAILED, strings.Join(failures, "; ")
+		return r
+	}
+	r.Status = SUITE_PASSED
+	return r
+}
+
+// The last MetricsResponse of every type in the metrics stream, by the choice of the response
+func latestMetrics(stream *bytes.Buffer) map[string]gosnappi.MetricsResponse {
+	latest := map[string]gosnappi.MetricsResponse{}
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		_, responses := parseMetricsRecord(line)
+		if responses == nil {
+			mr := gosnappi.NewMetricsResponse()
+			if mr.Unmarshal().FromJson(line) != nil {
+				continue // an event record
+			}
+			responses = []gosnappi.MetricsResponse{mr}
+		}
+		for _, mr := range responses {
+			latest[string(mr.Choice())] = mr
+		}
+	}
+	return latest
+}
+
+func suiteMetrics(latest map[string]gosnappi.MetricsResponse) suiteKeyMetrics {
+	m := suiteKeyMetrics{}
+	if mr, ok := latest["flow_metrics"]; ok && len(mr.FlowMetrics().Items()) > 0 {
+		for _, f := range mr.FlowMetrics().Items() {
+			if f.HasFramesTx() {
+				m.FramesTx += f.FramesTx()
+			}
+			if f.HasFramesRx() {
+				m.FramesRx += f.FramesRx()
+			}
+		}
+		m.reported = true
+	} else if mr, ok := latest["port_metrics"]; ok && len(mr.PortMetrics().Items()) > 0 {
+		for _, p := range mr.PortMetrics().Items() {
+			if p.HasFramesTx() {
+				m.FramesTx += p.FramesTx()
+			}
+			if p.HasFramesRx() {
+				m.FramesRx += p.FramesRx()
+			}
+		}
+		m.reported = true
+	}
+	if m.FramesTx > m.FramesRx {
+		m.LossPct = float64(m.FramesTx-m.FramesRx) / float64(m.FramesTx) * 100
+	}
+	return m
+}
+
+var logMsgRegexp = regexp.MustCompile(`level=(?:error|fatal) msg=("(?:[^"\\]|\\.)*"|\S+)`)
+
+// Message of the last error in a log of "otgen run"
+func lastLogError(stderr string) string {
+	m := logMsgRegexp.FindAllStringSubmatch(stderr, -1)
+	if len(m) == 0 {
+		return ""
+	}
+	msg := m[len(m)-1][1]
+	if s, err := strconv.Unquote(msg); err == nil {
+		return s
+	}
+	return msg
+}
+
+// Print a table of test results and the totals
+func printSuiteSummary(results []suiteResult, duration time.Duration) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"test", "result", "duration", "frames_tx", "frames_rx", "loss_pct", "details"})
+	table.SetAutoWrapText(false)
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+		tx, rx, loss := "", "", ""
+		if r.Metrics.reported {
+			tx = strconv.FormatUint(r.Metrics.FramesTx, 10)
+			rx = strconv.FormatUint(r.Metrics.FramesRx, 10)
+			loss = fmt.Sprintf("%.3f", r.Metrics.LossPct)
+		}
+		table.Append([]string{r.Name, r.Status, r.Duration.Round(time.Millisecond).String(), tx, rx, loss, r.Message})
+	}
+	table.Render()
+	fmt.Printf("%d tests: %d passed, %d failed, %d errors, %d skipped in %s\n", len(results),
+		counts[SUITE_PASSED], counts[SUITE_FAILED], counts[SUITE_ERROR], counts[SUITE_SKIPPED], duration.Round(time.Millisecond))
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name       string           `xml:"name,attr"`
+	Classname  string           `xml:"classname,attr"`
+	File       string           `xml:"file,attr"`
+	Time       string           `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Failure    *junitMessage    `xml:"failure,omitempty"`
+	Error      *junitMessage    `xml:"error,omitempty"`
+	Skipped    *junitMessage    `xml:"skipped,omitempty"`
+	SystemErr  string           `xml:"system-err,omitempty"`
+}
+
+type junitProperties struct {
+	Properties []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// Write results of all the tests as one JUnit test suite
+func writeSuiteJUnit(file string, results []suiteResult, start time.Time, duration time.Duration) {
+	suite := junitTestSuite{
+		Name:      "otgen suite",
+		Tests:     len(results),
+		Time:      junitSeconds(duration),
+		Timestamp: start.Format("2006-01-02T15:04:05"),
+	}
+	for _, r := range results {
+		tc := junitTestCase{Name: r.Name, Classname: "otgen", File: r.File, Time: junitSeconds(r.Duration), SystemErr: r.Stderr}
+		if r.Metrics.reported {
+			tc.Properties = &junitProperties{[]junitProperty{
+				{"frames_tx", strconv.FormatUint(r.Metrics.FramesTx, 10)},
+				{"frames_rx", strconv.FormatUint(r.Metrics.FramesRx, 10)},
+				{"loss_pct", fmt.Sprintf("%.3f", r.Metrics.LossPct)},
+			}}
+		}
+		switch r.Status {
+		case SUITE_FAILED:
+			tc.Failure = &junitMessage{r.Message}
+			suite.Failures++
+		case SUITE_ERROR:
+			tc.Error = &junitMessage{r.Message}
+			suite.Errors++
+		case SUITE_SKIPPED:
+			tc.Skipped = &junitMessage{r.Message}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suites := junitTestSuites{
+		Name:     "otgen",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Errors:   suite.Errors,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+	b, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(file, append([]byte(xml.Header), append(b, '\n')...), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
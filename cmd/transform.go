@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"os"
 	"text/template"
+	"time"
 
 	"github.com/open-traffic-generator/snappi/gosnappi"
 	"github.com/open-traffic-generator/snappi/gosnappi/otg"
@@ -34,17 +35,35 @@ import (
 )
 
 const (
-	METRIC_PORT    = "port"
-	METRIC_FLOW    = "flow"
-	COUNTER_FRAMES = "frames"
-	COUNTER_BYTES  = "bytes"
-	COUNTER_PPS    = "pps"
-	COUNTER_TPUT   = "tput"
+	METRIC_PORT     = "port"
+	METRIC_FLOW     = "flow"
+	METRIC_BGP4     = "bgp4"
+	METRIC_BGP6     = "bgp6"
+	METRIC_ISIS     = "isis"
+	COUNTER_FRAMES  = "frames"
+	COUNTER_BYTES   = "bytes"
+	COUNTER_PPS     = "pps"
+	COUNTER_TPUT    = "tput"
+	COUNTER_DELTA   = "delta"
+	COUNTER_BPS     = "bps"
+	COUNTER_LOSS    = "loss"
+	COUNTER_LATENCY = "latency"
 )
 
-var transformMetrics string      // Metrics type to report: "port" for PortMetrics, "flow" for FlowMetrics
+// MetricsResponse choice for each metrics type supported by built-in templates
+var transformMetricsChoice = map[string]string{
+	METRIC_PORT: "port_metrics",
+	METRIC_FLOW: "flow_metrics",
+	METRIC_BGP4: "bgpv4_metrics",
+	METRIC_BGP6: "bgpv6_metrics",
+	METRIC_ISIS: "isis_metrics",
+}
+
+var transformMetrics string      // Metrics type to report: "port" for PortMetrics, "flow" for FlowMetrics, or a protocol like "bgp4"
 var transformCounters string     // Metric counters to transform:  "frames" for frame count,  "bytes" for byte count,  "pps" for frame rate", "tput" for byte rate)
 var transformTemplateFile string // Go template file for transform
+var transformFields []string     // Fields of metrics items to output as columns, with --format csv or tsv
+var transformPortPairsStr string // Port pairs for --counters loss of port metrics, like "p1:p2,p2:p1"
 
 // transformCmd represents the transform command
 var transformCmd = &cobra.Command{
@@ -55,6 +74,9 @@ Transform raw OTG metrics into a format suitable for further processing.
 
 If no parameters is provided, transform validates input for a match with
 OTG MetricsResponse data structure, and if matched, outputs it as is.
+Event records produced by "otgen run --events" are passed through unchanged.
+Combined records produced by "otgen run --combined" are transformed one
+MetricsResponse at a time.
 
 For more information, go to https://github.com/open-traffic-generator/otgen
 `,
@@ -63,6 +85,29 @@ For more information, go to https://github.com/open-traffic-generator/otgen
 		var template string
 		var err error
 
+		if transformSelect != "" {
+			steps, _ := parseSelect(transformSelect) // validated by PreRunE
+			transformStdIn(func(mr gosnappi.MetricsResponse, timestamp string) {
+				selectMetricsResponse(steps, mr)
+			}, false, true)
+			return
+		}
+		if transformFormat == FORMAT_JSON && transformProtocolCounterFields[transformMetrics] != nil {
+			iw := &itemWriter{fields: transformFields}
+			transformStdIn(iw.write, false, true)
+			return
+		}
+		if transformFormat == FORMAT_CSV || transformFormat == FORMAT_TSV {
+			rw := newRowWriter(transformFormat, transformFields)
+			transformStdIn(rw.write, false, false)
+			return
+		}
+		if transformFormat == FORMAT_INFLUX || transformFormat == FORMAT_GRAPHITE {
+			lw := newLineWriter(transformFormat, transformSink)
+			transformStdIn(lw.write, false, false)
+			return
+		}
+
 		if transformTemplateFile != "" { // Read template from file
 			templatebytes, err = os.ReadFile(transformTemplateFile)
 			if err != nil {
@@ -81,6 +126,12 @@ For more information, go to https://github.com/open-traffic-generator/otgen
 					template = otgTemplatePortMetricFrameRate
 				case COUNTER_TPUT:
 					template = otgTemplatePortMetricByteRate
+				case COUNTER_DELTA:
+					template = otgTemplatePortMetricFramesDelta
+				case COUNTER_BPS:
+					template = otgTemplatePortMetricBitRate
+				case COUNTER_LOSS:
+					template = otgTemplatePortPairLoss
 				case "":
 					template = otgTemplatePortMetricFrames
 				default:
@@ -94,6 +145,14 @@ For more information, go to https://github.com/open-traffic-generator/otgen
 					template = otgTemplateFlowMetricBytes
 				case COUNTER_PPS:
 					template = otgTemplateFlowMetricFrameRate
+				case COUNTER_DELTA:
+					template = otgTemplateFlowMetricFramesDelta
+				case COUNTER_BPS:
+					template = otgTemplateFlowMetricBitRate
+				case COUNTER_LOSS:
+					template = otgTemplateFlowMetricLoss
+				case COUNTER_LATENCY:
+					template = otgTemplateFlowMetricLatency
 				case "":
 					template = otgTemplateFlowMetricFrames
 				default:
@@ -111,18 +170,75 @@ For more information, go to https://github.com/open-traffic-generator/otgen
 		switch transformMetrics {
 		case METRIC_PORT:
 		case METRIC_FLOW:
+		case METRIC_BGP4, METRIC_BGP6, METRIC_ISIS:
 		case "": // this would mean --metrics was not defined, will use passthrough mode
 		default:
 			log.Fatalf("Unsupported metrics type requested: %s", transformMetrics)
 		}
-		switch transformCounters {
-		case COUNTER_FRAMES:
-		case COUNTER_BYTES:
-		case COUNTER_PPS:
-		case COUNTER_TPUT:
-		case "":
+		if transformSelect != "" {
+			if transformFormat != FORMAT_JSON {
+				log.Fatalf("Incorrect parameters: --select is supported only with --format %s", FORMAT_JSON)
+			}
+			if _, err := parseSelect(transformSelect); err != nil {
+				log.Fatalf("Incorrect --select %s: %s", transformSelect, err)
+			}
+		}
+		if _, ok := latencyUnits[transformLatencyUnit]; !ok {
+			log.Fatalf("Unsupported latency unit requested: %s", transformLatencyUnit)
+		}
+		if transformPortPairsStr != "" {
+			var err error
+			if transformPortPairs, err = parsePortPairs(transformPortPairsStr); err != nil {
+				log.Fatalf("Incorrect --port-pairs %s: %s", transformPortPairsStr, err)
+			}
+		}
+		switch transformFormat {
+		case FORMAT_JSON:
+			if transformProtocolCounterFields[transformMetrics] != nil {
+				var err error
+				transformFields, err = parseCounterFields(transformMetrics, transformCounters)
+				if err != nil {
+					log.Fatalf("Unsupported metrics counters requested: %s", err)
+				}
+				break
+			}
+			switch transformCounters {
+			case COUNTER_FRAMES:
+			case COUNTER_BYTES:
+			case COUNTER_PPS:
+			case COUNTER_TPUT:
+			case COUNTER_DELTA:
+			case COUNTER_BPS:
+			case COUNTER_LOSS:
+				if transformMetrics == METRIC_PORT && len(transformPortPairs) == 0 {
+					log.Fatalf("Incorrect parameters: --counters %s for port metrics requires --port-pairs", COUNTER_LOSS)
+				}
+			case COUNTER_LATENCY:
+				if transformMetrics != METRIC_FLOW {
+					log.Fatalf("Incorrect parameters: --counters %s is supported only for flow metrics", COUNTER_LATENCY)
+				}
+			case "":
+			default:
+				log.Fatalf("Unsupported metrics counter requested: %s", transformCounters)
+			}
+		case FORMAT_CSV, FORMAT_TSV:
+			if transformMetrics == "" {
+				log.Fatalf("Metrics type to transform has to be provided via --metrics for --format %s", transformFormat)
+			}
+			var err error
+			transformFields, err = parseCounterFields(transformMetrics, transformCounters)
+			if err != nil {
+				log.Fatalf("Unsupported metrics counters requested: %s", err)
+			}
+		case FORMAT_INFLUX, FORMAT_GRAPHITE:
+			if transformCounters != "" {
+				log.Fatalf("Incorrect parameters: --counters is not supported with --format %s, all the fields are written", transformFormat)
+			}
 		default:
-			log.Fatalf("Unsupported metrics counter requested: %s", transformCounters)
+			log.Fatalf("Unsupported output format requested: %s", transformFormat)
+		}
+		if transformSink != "" && transformFormat != FORMAT_INFLUX && transformFormat != FORMAT_GRAPHITE {
+			log.Fatalf("Incorrect parameters: --sink is supported only with --format %s or %s", FORMAT_INFLUX, FORMAT_GRAPHITE)
 		}
 		return nil
 	},
@@ -141,28 +257,72 @@ func init() {
 	// is called directly, e.g.:
 	// transformCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	transformCmd.Flags().StringVarP(&transformTemplateFile, "file", "f", "", "Go template file for transform")
-	transformCmd.Flags().StringVarP(&transformMetrics, "metrics", "m", "", fmt.Sprintf("Metrics type to transform:\n  \"%s\" for PortMetrics\n  \"%s\" for FlowMetrics\n", METRIC_PORT, METRIC_FLOW))
+	transformCmd.Flags().StringVarP(&transformMetrics, "metrics", "m", "", fmt.Sprintf("Metrics type to transform:\n  \"%s\" for PortMetrics\n  \"%s\" for FlowMetrics\n  \"%s\" for Bgpv4Metrics\n  \"%s\" for Bgpv6Metrics\n  \"%s\" for IsisMetrics\n", METRIC_PORT, METRIC_FLOW, METRIC_BGP4, METRIC_BGP6, METRIC_ISIS))
 	transformCmd.MarkFlagsMutuallyExclusive("metrics", "file") // either use parameters to control transformation, or provide a template file
-	transformCmd.Flags().StringVarP(&transformCounters, "counters", "c", "", fmt.Sprintf("Metric counters to transform:\n  \"%s\" for frame count (default)\n  \"%s\" for byte count\n  \"%s\" for frame rate, in packets per second\n  \"%s\" for throughput, in bytes per second (PortMetrics only)", COUNTER_FRAMES, COUNTER_BYTES, COUNTER_PPS, COUNTER_TPUT))
+	transformCmd.Flags().StringVarP(&transformCounters, "counters", "c", "", fmt.Sprintf("Metric counters to transform:\n  \"%s\" for frame count (default)\n  \"%s\" for byte count\n  \"%s\" for frame rate, in packets per second\n  \"%s\" for throughput, in bytes per second (PortMetrics only)\n  \"%s\" for frames counted since the previous sample\n  \"%s\" for throughput calculated from byte counts, in bits per second\n  \"%s\" for frames lost, and loss in percent\n  \"%s\" for minimum, maximum and average latency (FlowMetrics only)\nFor protocols, a comma-separated list of counters and fields of metrics:\n  \"%s\" for session state and flaps\n  \"%s\" for routes\n  \"%s\" for protocol messages\n  (default all of them)", COUNTER_FRAMES, COUNTER_BYTES, COUNTER_PPS, COUNTER_TPUT, COUNTER_DELTA, COUNTER_BPS, COUNTER_LOSS, COUNTER_LATENCY, COUNTER_SESSION, COUNTER_ROUTES, COUNTER_MESSAGES))
+	transformCmd.Flags().StringVarP(&transformLatencyUnit, "latency-unit", "", LATENCY_NS, fmt.Sprintf("Unit of latency for --counters %s: \"%s\", \"%s\" or \"%s\"", COUNTER_LATENCY, LATENCY_NS, LATENCY_US, LATENCY_MS))
+	transformCmd.Flags().StringVarP(&transformPortPairsStr, "port-pairs", "", "", "Ports frames are sent from and received on, for --counters loss of port metrics, like \"p1:p2,p2:p1\"")
 	transformCmd.MarkFlagsMutuallyExclusive("counters", "file") // either use parameters to control transformation, or provide a template file
+	transformCmd.Flags().StringVarP(&transformFormat, "format", "", FORMAT_JSON, fmt.Sprintf("Output format:\n  \"%s\" for JSON arrays (default)\n  \"%s\" for comma-separated values\n  \"%s\" for tab-separated values\n  \"%s\" for InfluxDB line protocol\n  \"%s\" for Graphite plaintext protocol\nWith \"%s\" and \"%s\", --counters is a comma-separated list of counters and fields of metrics, like frames,bytes_rx", FORMAT_JSON, FORMAT_CSV, FORMAT_TSV, FORMAT_INFLUX, FORMAT_GRAPHITE, FORMAT_CSV, FORMAT_TSV))
+	transformCmd.MarkFlagsMutuallyExclusive("format", "file")
+	transformCmd.Flags().StringVarP(&transformSelect, "select", "s", "", "Expression selecting fields of MetricsResponse JSON with proto names, like \"flow_metrics[*].{name,frames_rx,loss}\".\nSelected objects are output as flat JSON arrays, for display")
+	transformCmd.MarkFlagsMutuallyExclusive("select", "file")
+	transformCmd.MarkFlagsMutuallyExclusive("select", "counters")
+	transformCmd.Flags().StringVarP(&transformSink, "sink", "", "", fmt.Sprintf("Socket to write \"%s\" or \"%s\" lines to instead of stdout, like udp://127.0.0.1:8089 or tcp://127.0.0.1:2003", FORMAT_INFLUX, FORMAT_GRAPHITE))
 }
 
 func transformStdInWithTemplate(t string) {
+	transformStdIn(func(mr gosnappi.MetricsResponse, timestamp string) {
+		transformMetricsResponse(mr, t, timestamp)
+	}, t == otgTemplateMetricResponsePassThrough, true)
+}
+
+// Read the metrics stream from stdin, and transform every MetricsResponse in it. The timestamp is the time of a combined
+// record the response came in, or the time it was read. With passRecords, combined records are output as is, and with
+// passEvents, so are event records
+func transformStdIn(transform func(mr gosnappi.MetricsResponse, timestamp string), passRecords bool, passEvents bool) {
 	scanner := bufio.NewScanner(os.Stdin)
 
 	for scanner.Scan() {
 		text := scanner.Text()
 
+		if isEventRecord(text) { // pass event records through as is, for display to use
+			if passEvents {
+				fmt.Println(text)
+			}
+			continue
+		}
+
+		if record, responses := parseMetricsRecord(text); responses != nil {
+			if passRecords { // all metrics in the record are valid, output it as is
+				fmt.Println(text)
+				continue
+			}
+			for _, mr := range responses {
+				if e, ok := record.Labels[LABEL_ENDPOINT]; ok { // tell metrics from different endpoints apart by name
+					mr = prefixMetricNames(mr, endpointPrefix(e)+"/")
+				}
+				// built-in templates are applied only to the metrics type they were made for
+				if transformTemplateFile == "" && transformMetrics != "" && string(mr.Choice()) != transformMetricsChoice[transformMetrics] {
+					continue
+				}
+				transform(mr, record.Timestamp)
+			}
+			continue
+		}
+
 		mr := gosnappi.NewMetricsResponse()
 		err := mr.Unmarshal().FromJson(text)
 		if err != nil {
 			log.Fatal(err)
 		}
-		transformMetricsResponse(mr, t)
+		transform(mr, time.Now().UTC().Format(time.RFC3339Nano))
 	}
 }
 
-func transformMetricsResponse(mr gosnappi.MetricsResponse, tmpl string) {
+// Execute the template on the MetricsResponse. The timestamp of the sample is used for rates of counters
+func transformMetricsResponse(mr gosnappi.MetricsResponse, tmpl string, timestamp string) {
+	setSampleTime(timestamp)
 	t, err := template.New("default").
 		Funcs(template.FuncMap{
 			"otgMetricsResponseToJson": func(r *otg.MetricsResponse) string {
@@ -179,6 +339,8 @@ func transformMetricsResponse(mr gosnappi.MetricsResponse, tmpl string) {
 				return fmt.Sprintf(f, c)
 			},
 		}).
+		Funcs(counterTemplateFuncs).
+		Funcs(templateFuncs).
 		Parse(tmpl)
 
 	if err != nil {
@@ -216,11 +378,25 @@ const (
 	otgTemplatePortMetricFrameRate = `[{{range $i, $p := .PortMetrics}}{{if $i}},{{end}}{"name": "{{ $p.Name }}", "frames_tx_rate": "{{ $p.FramesTxRate }}", "frames_rx_rate": "{{ $p.FramesRxRate }}"}{{end}}]
 `
 	otgTemplatePortMetricByteRate = `[{{range $i, $p := .PortMetrics}}{{if $i}},{{end}}{"name": "{{ $p.Name }}", "bytes_tx_rate": "{{ ratePrintf "%.0f" $p.BytesTxRate }}", "bytes_rx_rate": "{{ ratePrintf "%.0f" $p.BytesRxRate }}"}{{end}}]
+`
+	otgTemplatePortMetricFramesDelta = `[{{range $i, $p := .PortMetrics}}{{if $i}},{{end}}{"name": "{{ $p.Name }}", "frames_tx_delta": "{{ delta $p "frames_tx" }}", "frames_rx_delta": "{{ delta $p "frames_rx" }}"}{{end}}]
+`
+	otgTemplatePortMetricBitRate = `[{{range $i, $p := .PortMetrics}}{{if $i}},{{end}}{"name": "{{ $p.Name }}", "bps_tx": "{{ printf "%.0f" (bps $p "bytes_tx") }}", "bps_rx": "{{ printf "%.0f" (bps $p "bytes_rx") }}"}{{end}}]
+`
+	otgTemplatePortPairLoss = `[{{range $i, $p := portPairs .}}{{if $i}},{{end}}{"name": "{{ $p.Name }}", "frames_lost": "{{ framesLost $p.FramesTx $p.FramesRx }}", "loss_pct": "{{ printf "%.2f" (lossPct $p.FramesTx $p.FramesRx) }}"}{{end}}]
 `
 	otgTemplateFlowMetricFrames = `[{{range $i, $f := .FlowMetrics}}{{if $i}},{{end}}{"name": "{{ $f.Name }}", "frames_tx": "{{ $f.FramesTx }}", "frames_rx": "{{ $f.FramesRx }}"}{{end}}]
 `
 	otgTemplateFlowMetricBytes = `[{{range $i, $f := .FlowMetrics}}{{if $i}},{{end}}{"name": "{{ $f.Name }}", "bytes_tx": "{{ $f.BytesTx }}", "bytes_rx": "{{ $f.BytesRx }}"}{{end}}]
 `
 	otgTemplateFlowMetricFrameRate = `[{{range $i, $f := .FlowMetrics}}{{if $i}},{{end}}{"name": "{{ $f.Name }}", "frames_tx_rate": "{{ ratePrintf "%.0f" $f.FramesTxRate }}", "frames_rx_rate": "{{ ratePrintf "%.0f" $f.FramesRxRate }}"}{{end}}]
+`
+	otgTemplateFlowMetricFramesDelta = `[{{range $i, $f := .FlowMetrics}}{{if $i}},{{end}}{"name": "{{ $f.Name }}", "frames_tx_delta": "{{ delta $f "frames_tx" }}", "frames_rx_delta": "{{ delta $f "frames_rx" }}"}{{end}}]
+`
+	otgTemplateFlowMetricLoss = `[{{range $i, $f := .FlowMetrics}}{{if $i}},{{end}}{"name": "{{ $f.Name }}", "frames_lost": "{{ framesLost $f.GetFramesTx $f.GetFramesRx }}", "loss_pct": "{{ printf "%.2f" (lossPct $f.GetFramesTx $f.GetFramesRx) }}"{{if $f.Loss}}, "loss": "{{ printf "%.2f" $f.GetLoss }}"{{end}}}{{end}}]
+`
+	otgTemplateFlowMetricLatency = `[{{range $i, $f := .FlowMetrics}}{{if $i}},{{end}}{"name": "{{ $f.Name }}", "latency_minimum_{{ latencyUnit }}": "{{ latency $f.Latency "minimum" }}", "latency_maximum_{{ latencyUnit }}": "{{ latency $f.Latency "maximum" }}", "latency_average_{{ latencyUnit }}": "{{ latency $f.Latency "average" }}"}{{end}}]
+`
+	otgTemplateFlowMetricBitRate = `[{{range $i, $f := .FlowMetrics}}{{if $i}},{{end}}{"name": "{{ $f.Name }}", "bps_tx": "{{ printf "%.0f" (bps $f "bytes_tx") }}", "bps_rx": "{{ printf "%.0f" (bps $f "bytes_rx") }}"}{{end}}]
 `
 )
@@ -0,0 +1,292 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	OTG_TRANSPORT         = "${OTG_TRANSPORT}"   // Env var for API transport
+	OTG_DEFAULT_TRANSPORT = "http"               // Default API transport
+	OTG_INSECURE          = "${OTG_INSECURE}"    // Env var to ignore X.509 certificate validation of API endpoint, when set to "true"
+	OTG_CA_CERT           = "${OTG_CA_CERT}"     // Env var for CA certificates bundle to validate API endpoint with
+	OTG_CLIENT_CERT       = "${OTG_CLIENT_CERT}" // Env var for client certificate to present to API endpoint
+	OTG_CLIENT_KEY        = "${OTG_CLIENT_KEY}"  // Env var for private key of the client certificate
+	OTG_API_TOKEN         = "${OTG_API_TOKEN}"   // Env var for bearer token to authenticate with API endpoint
+	OTG_PROXY             = "${OTG_PROXY}"       // Env var for HTTP(S) proxy to reach API endpoint through
+)
+
+var otgTransport string  // Transport to use for OTG API: "http" or "grpc"
+var otgIgnoreX509 bool   // Ignore X.509 certificate validation of OTG API endpoint
+var otgCACert string     // CA certificates bundle file, in PEM format
+var otgClientCert string // Client certificate file, in PEM format
+var otgClientKey string  // Private key file of the client certificate, in PEM format
+var otgToken string      // Bearer token
+var otgProxy string      // HTTP(S) proxy URL
+
+var otgTLSConfig *tls.Config // Parsed TLS client configuration
+var otgProxyURL *url.URL     // Parsed proxy URL
+
+// Add flags controlling how to connect to OTG API endpoints to a command that uses the API
+func addApiFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&otgTransport, "transport", "", envSubstOrDefault(OTG_TRANSPORT, OTG_DEFAULT_TRANSPORT), "Transport to use for OTG API: \"http\" or \"grpc\". Overrides ENV:OTG_TRANSPORT")
+	cmd.Flags().BoolVarP(&otgIgnoreX509, "insecure", "k", envSubstOrDefault(OTG_INSECURE, "false") == "true", "Ignore X.509 certificate validation of OTG API endpoint. Overrides ENV:OTG_INSECURE")
+	cmd.Flags().StringVarP(&otgCACert, "ca-cert", "", envSubstOrDefault(OTG_CA_CERT, ""), "CA certificates file in PEM format to validate OTG API endpoint with. Overrides ENV:OTG_CA_CERT")
+	cmd.Flags().StringVarP(&otgClientCert, "client-cert", "", envSubstOrDefault(OTG_CLIENT_CERT, ""), "Client certificate file in PEM format to present to OTG API endpoint. Overrides ENV:OTG_CLIENT_CERT")
+	cmd.Flags().StringVarP(&otgClientKey, "client-key", "", envSubstOrDefault(OTG_CLIENT_KEY, ""), "Private key file in PEM format for --client-cert. Overrides ENV:OTG_CLIENT_KEY")
+	cmd.Flags().StringVarP(&otgToken, "token", "", "", "Bearer token to authenticate with OTG API endpoint. Overrides ENV:OTG_API_TOKEN")
+	cmd.Flags().StringVarP(&otgApiTimeoutStr, "api-timeout", "", "5m", "Time limit for a single OTG API call, 0 for no limit. Valid time units are 'ms', 's', 'm', 'h'")
+	cmd.Flags().StringVarP(&otgProxy, "proxy", "", envSubstOrDefault(OTG_PROXY, ""), "HTTP(S) proxy URL to reach OTG API endpoint through. Overrides ENV:OTG_PROXY")
+	profileFlag(cmd, "transport", OTG_TRANSPORT, OTG_DEFAULT_TRANSPORT)
+	profileFlag(cmd, "insecure", OTG_INSECURE, "false")
+	profileFlag(cmd, "ca-cert", OTG_CA_CERT, "")
+	profileFlag(cmd, "client-cert", OTG_CLIENT_CERT, "")
+	profileFlag(cmd, "client-key", OTG_CLIENT_KEY, "")
+	profileFlag(cmd, "proxy", OTG_PROXY, "")
+}
+
+// Validate flags added by addApiFlags, and load certificates
+func parseApiFlags() {
+	switch otgTransport {
+	case "http":
+	case "grpc":
+	default:
+		log.Fatalf("Unsupported API transport requested: %s", otgTransport)
+	}
+
+	var err error
+	otgApiTimeout, err = time.ParseDuration(otgApiTimeoutStr)
+	if err != nil {
+		log.Fatalf("Incorrect format for --api-timeout: %s", err)
+	}
+
+	if otgToken == "" { // not a default value of the flag, to keep the token out of --help output
+		otgToken = envSubstOrDefault(OTG_API_TOKEN, "")
+	}
+
+	if otgProxy != "" {
+		u, err := url.Parse(otgProxy)
+		if err != nil || u.Host == "" {
+			log.Fatalf("Incorrect proxy URL: %s", otgProxy)
+		}
+		otgProxyURL = u
+	}
+
+	if otgCACert == "" && otgClientCert == "" && otgClientKey == "" {
+		return
+	}
+	otgTLSConfig = &tls.Config{InsecureSkipVerify: otgIgnoreX509}
+	if otgCACert != "" {
+		pem, err := os.ReadFile(otgCACert)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("No certificates found in %s", otgCACert)
+		}
+		otgTLSConfig.RootCAs = pool
+	}
+	if otgClientCert != "" || otgClientKey != "" {
+		if otgClientCert == "" || otgClientKey == "" {
+			log.Fatal("Both --client-cert and --client-key have to be provided")
+		}
+		cert, err := tls.LoadX509KeyPair(otgClientCert, otgClientKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		otgTLSConfig.Certificates = []tls.Certificate{cert}
+	}
+}
+
+// TLS client configuration for the endpoint, taking --insecure into account
+func apiTLSConfig() *tls.Config {
+	if otgTLSConfig != nil {
+		return otgTLSConfig.Clone()
+	}
+	return &tls.Config{InsecureSkipVerify: otgIgnoreX509}
+}
+
+// HTTP transport of gosnappi can't be given TLS settings, a proxy or extra headers. When any of them is needed,
+// gosnappi is pointed to a reverse proxy on the loopback interface, that forwards requests to the endpoint with
+// the settings applied. Returns the location for gosnappi to use.
+// The proxy adds the credentials of the user to the requests, so it only forwards requests with a random secret
+// as the first element of the path, known to this process alone. gosnappi can't connect over a unix socket, which
+// would have been protected by file permissions instead
+func httpApiLocation(location string) (string, error) {
+	if otgTLSConfig == nil && otgToken == "" && otgProxyURL == nil {
+		return location, nil
+	}
+	target, err := url.Parse(location)
+	if err != nil || target.Host == "" {
+		return "", fmt.Errorf("incorrect OTG API endpoint URL: %s", location)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	prefix := "/" + hex.EncodeToString(secret)
+
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: target.Scheme, Host: target.Host})
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Host = target.Host
+		if otgToken != "" {
+			req.Header.Set("Authorization", "Bearer "+otgToken)
+		}
+	}
+	proxy.Transport = &http.Transport{
+		Proxy:           http.ProxyURL(otgProxyURL),
+		TLSClientConfig: apiTLSConfig(),
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		http.Error(w, err.Error(), http.StatusBadGateway) // gosnappi reports the body as an error message
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p := req.URL.Path
+		if len(p) <= len(prefix) || subtle.ConstantTimeCompare([]byte(p[:len(prefix)]), []byte(prefix)) != 1 || p[len(prefix)] != '/' {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		req.URL.Path = p[len(prefix):]
+		req.URL.RawPath = ""
+		proxy.ServeHTTP(w, req)
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		// API calls fail from now on, and report the error to their callers
+		err := http.Serve(l, handler)
+		log.Errorf("Stopped forwarding OTG API requests to %s: %v", location, err)
+		l.Close()
+	}()
+	log.Debugf("Forwarding OTG API requests from %s to %s", l.Addr(), location)
+	// gosnappi resolves API paths relative to the location, so the secret has to stay a directory of it
+	return (&url.URL{Scheme: "http", Host: l.Addr().String(), Path: prefix + "/" + strings.TrimPrefix(target.Path, "/")}).String(), nil
+}
+
+// gRPC connection to the endpoint with TLS, token and proxy settings applied. Returns nil if there are no
+// settings to apply, for gosnappi to connect by itself
+func grpcApiConnection(location string) *grpc.ClientConn {
+	if otgTLSConfig == nil && otgToken == "" && otgProxyURL == nil {
+		return nil
+	}
+	opts := []grpc.DialOption{}
+	if otgTLSConfig != nil {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(apiTLSConfig())))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if otgToken != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{token: otgToken, secure: otgTLSConfig != nil}))
+	}
+	if otgProxyURL != nil {
+		opts = append(opts, grpc.WithContextDialer(proxyDialer(otgProxyURL)))
+	}
+	conn, err := grpc.NewClient(location, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return conn
+}
+
+// tokenCredentials adds a bearer token to every gRPC call
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+// Dialer that reaches the address through an HTTP proxy using the CONNECT method
+func proxyDialer(proxy *url.URL) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", proxy.Host)
+		if err != nil {
+			return nil, err
+		}
+		if proxy.Scheme == "https" {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			conn = tlsConn
+		}
+		req := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: http.Header{},
+		}
+		if proxy.User != nil {
+			password, _ := proxy.User.Password()
+			req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(proxy.User.Username()+":"+password)))
+		}
+		if err := req.Write(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		br := bufio.NewReader(conn)
+		res, err := http.ReadResponse(br, req)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", proxy.Host, addr, res.Status)
+		}
+		return conn, nil
+	}
+}
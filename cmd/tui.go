@@ -0,0 +1,307 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-traffic-generator/otgen/display"
+	"github.com/open-traffic-generator/snappi/gosnappi"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var tuiApiURL string          // URL of OTG API endpoint to control
+var tuiFile string            // OTG configuration file to apply, if any
+var tuiPullIntervalStr string // Interval to pull metrics
+var tuiPullInterval time.Duration
+
+// tuiCmd represents the tui command
+var tuiCmd = &cobra.Command{
+	Use:   "tui",
+	Short: "Interactive control panel for a traffic generator",
+	Long: `
+Interactive control panel for a traffic generator. Shows ports, devices, flows
+and BGP sessions of the OTG configuration, with live charts and tables of their
+metrics, and lets the operator start and stop flows and protocols, and change
+flow rates.
+
+With --file, the configuration is applied first. Otherwise, the configuration
+already applied to the traffic generator is used. Traffic and protocols are
+left as they are when the control panel is closed.
+
+For more information, go to https://github.com/open-traffic-generator/otgen
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		startTime = time.Now()
+		api := newOtgApi(tuiApiURL)
+		var config gosnappi.Config
+		if tuiFile != "" {
+			otgbytes, err := os.ReadFile(tuiFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			config = gosnappi.NewConfig()
+			if err := config.Unmarshal().FromYaml(string(otgbytes)); err != nil { // YAML is a superset of JSON
+				log.Fatal(err)
+			}
+			applyConfig(api, config)
+		} else {
+			var err error
+			config, err = api.GetConfig()
+			checkOTGError(api, err)
+		}
+
+		ctrl := &tuiController{api: api, config: config, rates: map[string]string{}}
+		panel, err := display.NewPanel(ctrl, "frames_rx_rate")
+		if err != nil {
+			log.Fatal(err)
+		}
+		// the control panel takes over the terminal, errors are shown in its status line instead
+		log.SetOutput(io.Discard)
+		err = panel.Run(tuiPullInterval, ctrl.poll)
+		log.SetOutput(os.Stderr)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		setLogLevel(cmd, logLevel)
+		parseApiFlags()
+		var err error
+		tuiPullInterval, err = time.ParseDuration(tuiPullIntervalStr)
+		if err != nil {
+			log.Fatalf("Incorrect format for --interval: %s", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tuiCmd)
+
+	tuiCmd.Flags().StringVarP(&tuiApiURL, "api", "a", envSubstOrDefault(OTG_API, OTG_DEFAULT_API), "URL of OTG API endpoint. Overrides ENV:OTG_API")
+	profileFlag(tuiCmd, "api", OTG_API, OTG_DEFAULT_API)
+	addApiFlags(tuiCmd)
+	tuiCmd.Flags().StringVarP(&tuiFile, "file", "f", "", "OTG configuration file to apply, in YAML or JSON format (default use the configuration already applied)")
+	tuiCmd.Flags().StringVarP(&tuiPullIntervalStr, "interval", "i", envSubstOrDefault(OTG_INTERVAL, OTG_DEFAULT_INTERVAL), "Interval to pull OTG metrics. Valid time units are 'ms', 's', 'm', 'h'. Overrides ENV:OTG_INTERVAL")
+	profileFlag(tuiCmd, "interval", OTG_INTERVAL, OTG_DEFAULT_INTERVAL)
+}
+
+// tuiController performs actions requested on the control panel against the OTG API endpoint
+type tuiController struct {
+	api    gosnappi.Api
+	apiMu  sync.Mutex // Serializes calls on api, actions and polls run in parallel and a handle is not safe for that
+	config gosnappi.Config
+	mu     sync.Mutex
+	rates  map[string]string // Rates changed from the control panel, by flow name
+}
+
+func (c *tuiController) StartFlows(names []string) error {
+	return c.setFlowTransmit(names, gosnappi.StateTrafficFlowTransmitState.START)
+}
+
+func (c *tuiController) StopFlows(names []string) error {
+	return c.setFlowTransmit(names, gosnappi.StateTrafficFlowTransmitState.STOP)
+}
+
+func (c *tuiController) setFlowTransmit(names []string, state gosnappi.StateTrafficFlowTransmitStateEnum) error {
+	cs := gosnappi.NewControlState()
+	ft := cs.Traffic().FlowTransmit().SetState(state)
+	if len(names) > 0 {
+		ft.SetFlowNames(names)
+	}
+	c.apiMu.Lock()
+	defer c.apiMu.Unlock()
+	_, err := c.api.SetControlState(cs)
+	return apiError(err)
+}
+
+func (c *tuiController) StartProtocols() error {
+	return c.setProtocols(gosnappi.StateProtocolAllState.START)
+}
+
+func (c *tuiController) StopProtocols() error {
+	return c.setProtocols(gosnappi.StateProtocolAllState.STOP)
+}
+
+func (c *tuiController) setProtocols(state gosnappi.StateProtocolAllStateEnum) error {
+	cs := gosnappi.NewControlState()
+	cs.Protocol().All().SetState(state)
+	c.apiMu.Lock()
+	defer c.apiMu.Unlock()
+	_, err := c.api.SetControlState(cs)
+	return apiError(err)
+}
+
+func (c *tuiController) SetFlowRate(name string, rate string) error {
+	cu, err := newFlowsUpdate(c.config, map[string]string{name: rate})
+	if err != nil {
+		return err
+	}
+	c.apiMu.Lock()
+	_, err = c.api.UpdateConfig(cu)
+	c.apiMu.Unlock()
+	if err != nil {
+		return apiError(err)
+	}
+	c.mu.Lock()
+	c.rates[name] = rate
+	c.mu.Unlock()
+	return nil
+}
+
+// Metrics from the endpoint, in turn with the actions of the operator
+func (c *tuiController) getMetrics(req gosnappi.MetricsRequest) (gosnappi.MetricsResponse, error) {
+	c.apiMu.Lock()
+	defer c.apiMu.Unlock()
+	return c.api.GetMetrics(req)
+}
+
+// Collect the state of the configuration and its metrics for the control panel
+func (c *tuiController) poll() display.PanelState {
+	s := display.PanelState{}
+	var errs []error
+
+	portReq := gosnappi.NewMetricsRequest()
+	portReq.Port()
+	ports := map[string]gosnappi.PortMetric{}
+	if mr, err := c.getMetrics(portReq); err != nil {
+		errs = append(errs, apiError(err))
+	} else {
+		for _, m := range mr.PortMetrics().Items() {
+			ports[m.Name()] = m
+		}
+	}
+	for _, p := range c.config.Ports().Items() {
+		dp := display.DataPoint{display.NAME_FIELD: p.Name(), "location": p.Location()}
+		if m, ok := ports[p.Name()]; ok {
+			msg, _ := m.Marshal().ToProto()
+			addMetricFields(dp, msg, "link", "frames_tx", "frames_rx", "frames_tx_rate", "frames_rx_rate")
+		}
+		s.Ports = append(s.Ports, dp)
+	}
+
+	for _, d := range c.config.Devices().Items() {
+		addresses := []string{}
+		for _, e := range d.Ethernets().Items() {
+			for _, a := range e.Ipv4Addresses().Items() {
+				addresses = append(addresses, fmt.Sprintf("%s/%d", a.Address(), a.Prefix()))
+			}
+			for _, a := range e.Ipv6Addresses().Items() {
+				addresses = append(addresses, fmt.Sprintf("%s/%d", a.Address(), a.Prefix()))
+			}
+		}
+		s.Devices = append(s.Devices, strings.TrimSpace(d.Name()+"  "+strings.Join(addresses, " ")))
+	}
+
+	flowReq := gosnappi.NewMetricsRequest()
+	flowReq.Flow()
+	flows := map[string]gosnappi.FlowMetric{}
+	if mr, err := c.getMetrics(flowReq); err != nil {
+		errs = append(errs, apiError(err))
+	} else {
+		for _, m := range mr.FlowMetrics().Items() {
+			flows[m.Name()] = m
+		}
+	}
+	c.mu.Lock()
+	for _, f := range c.config.Flows().Items() {
+		rate, ok := c.rates[f.Name()]
+		if !ok {
+			rate = flowRateString(f.Rate())
+		}
+		dp := display.DataPoint{display.NAME_FIELD: f.Name(), "rate": rate}
+		if m, ok := flows[f.Name()]; ok {
+			msg, _ := m.Marshal().ToProto()
+			addMetricFields(dp, msg, "transmit", "frames_tx", "frames_rx", "frames_rx_rate")
+			dp["loss_pct"] = fmt.Sprintf("%.3f", flowLossPct(m))
+		}
+		s.Flows = append(s.Flows, dp)
+	}
+	c.mu.Unlock()
+
+	if len(configBgp4PeerNames(c.config)) > 0 {
+		bgpReq := gosnappi.NewMetricsRequest()
+		bgpReq.Bgpv4()
+		if mr, err := c.getMetrics(bgpReq); err != nil {
+			errs = append(errs, apiError(err))
+		} else {
+			for _, m := range mr.Bgpv4Metrics().Items() {
+				dp := display.DataPoint{display.NAME_FIELD: m.Name()}
+				msg, _ := m.Marshal().ToProto()
+				addMetricFields(dp, msg, "session_state", "session_flap_count", "routes_advertised", "routes_received")
+				s.Bgp = append(s.Bgp, dp)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		s.Err = errs[0]
+	}
+	return s
+}
+
+// Add fields of a metrics item to the data point, skipping the ones the traffic generator did not report. Enums are
+// added as strings, floating point numbers as float64 for them to be charted, and counters as uint64 or int64
+func addMetricFields(dp display.DataPoint, msg proto.Message, fields ...string) {
+	if msg == nil {
+		return
+	}
+	m := msg.ProtoReflect()
+	for _, f := range fields {
+		fd := m.Descriptor().Fields().ByName(protoreflect.Name(f))
+		if fd == nil || fd.HasPresence() && !m.Has(fd) {
+			continue
+		}
+		v := m.Get(fd)
+		switch fd.Kind() {
+		case protoreflect.EnumKind:
+			dp[f] = string(fd.Enum().Values().ByNumber(v.Enum()).Name())
+		case protoreflect.StringKind, protoreflect.BoolKind:
+			dp[f] = v.String()
+		case protoreflect.FloatKind, protoreflect.DoubleKind:
+			dp[f] = v.Float()
+		case protoreflect.Uint32Kind, protoreflect.Uint64Kind:
+			dp[f] = v.Uint()
+		default:
+			dp[f] = v.Int()
+		}
+	}
+}
+
+// Error of an API call with the messages reported by the endpoint, for the operator to read
+func apiError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errData, ok := gosnappi.FromError(err); ok && len(errData.Errors()) > 0 {
+		return errors.New(strings.Join(errData.Errors(), "; "))
+	}
+	return err
+}
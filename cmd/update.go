@@ -0,0 +1,222 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+	"github.com/spf13/cobra"
+)
+
+var updateApiURL string       // URL of OTG API endpoint to update the configuration on
+var updateFlowNamesStr string // Flow names to update, as provided via --name
+var updateFlowRate string     // New rate of the flows
+
+// updateCmd represents the update command
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update properties of an OTG configuration applied to a traffic generator",
+	Long: `
+Update properties of an OTG configuration already applied to a traffic generator,
+without applying the whole configuration again. Traffic keeps running.
+
+  For more information, go to https://github.com/open-traffic-generator/otgen
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Error("You must specify an OTG configuration object to update, one of the following: flow")
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		setLogLevel(cmd, logLevel)
+		return nil
+	},
+}
+
+// updateFlowCmd represents the update flow command
+var updateFlowCmd = &cobra.Command{
+	Use:   "flow",
+	Short: "Update rate of Traffic Flows",
+	Long: `
+Update rate of Traffic Flows applied to a traffic generator, while traffic is running.
+
+For more information, go to https://github.com/open-traffic-generator/otgen
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		api := newOtgApi(updateApiURL)
+		res, err := api.GetConfig()
+		checkOTGError(api, err)
+		rates := map[string]string{}
+		for _, n := range matchNames("flow", parseNameList(updateFlowNamesStr), configFlowNames(res)) {
+			rates[n] = updateFlowRate
+		}
+		updateFlows(api, res, rates)
+		log.Infof("Updated rate of %d flows to %s", len(rates), updateFlowRate)
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		setLogLevel(cmd, logLevel)
+		parseApiFlags()
+		if updateFlowNamesStr == "" {
+			log.Fatal("Flow names to update have to be provided via --name")
+		}
+		if err := setFlowRate(gosnappi.NewFlowRate(), updateFlowRate); err != nil {
+			log.Fatalf("Incorrect --rate: %s", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(updateCmd)
+	updateCmd.AddCommand(updateFlowCmd)
+
+	updateFlowCmd.Flags().StringVarP(&updateApiURL, "api", "a", envSubstOrDefault(OTG_API, OTG_DEFAULT_API), "URL of OTG API endpoint. Overrides ENV:OTG_API")
+	profileFlag(updateFlowCmd, "api", OTG_API, OTG_DEFAULT_API)
+	addApiFlags(updateFlowCmd)
+	updateFlowCmd.Flags().StringVarP(&updateFlowNamesStr, "name", "n", "", "Flow names to update, as a comma-separated list. Glob patterns are supported. Example: f1,web-*")
+	updateFlowCmd.Flags().StringVarP(&updateFlowRate, "rate", "r", "", "New rate, in % of line rate, pps, bps, kbps, mbps or gbps. Example: 50%")
+	updateFlowCmd.MarkFlagRequired("rate")
+}
+
+// Next smaller unit of rates set as whole numbers, for fractional values like "1.5gbps" to be set as "1500mbps"
+var smallerRateUnits = map[string]string{"gbps": "mbps", "mbps": "kbps", "kbps": "bps"}
+
+// Set the rate from a string like "50%", "1000pps", "10mbps" or "1gbps"
+func setFlowRate(rate gosnappi.FlowRate, s string) error {
+	v, unit, err := parseRate(s)
+	if err != nil {
+		return err
+	}
+	for unit != "%" && v != math.Trunc(v) {
+		smaller, ok := smallerRateUnits[unit]
+		if !ok {
+			return fmt.Errorf("incorrect rate %s: has to be a whole number of %s", s, unit)
+		}
+		v, unit = v*1000, smaller
+		if r := math.Round(v); math.Abs(v-r) < 1e-6 { // 1.1*1000 is not exactly 1100
+			v = r
+		}
+	}
+	if unit == "gbps" && v > math.MaxUint32 {
+		return fmt.Errorf("incorrect rate %s: too high", s)
+	}
+	switch unit {
+	case "%":
+		rate.SetPercentage(float32(v))
+	case "pps":
+		rate.SetPps(uint64(v))
+	case "kbps":
+		rate.SetKbps(uint64(v))
+	case "mbps":
+		rate.SetMbps(uint64(v))
+	case "gbps":
+		rate.SetGbps(uint32(v))
+	case "bps":
+		rate.SetBps(uint64(v))
+	}
+	return nil
+}
+
+// Format the rate like "50%" or "1000pps", the same way it can be set with setFlowRate
+func flowRateString(rate gosnappi.FlowRate) string {
+	switch rate.Choice() {
+	case gosnappi.FlowRateChoice.PERCENTAGE:
+		return strconv.FormatFloat(float64(rate.Percentage()), 'f', -1, 32) + "%"
+	case gosnappi.FlowRateChoice.BPS:
+		return fmt.Sprintf("%dbps", rate.Bps())
+	case gosnappi.FlowRateChoice.KBPS:
+		return fmt.Sprintf("%dkbps", rate.Kbps())
+	case gosnappi.FlowRateChoice.MBPS:
+		return fmt.Sprintf("%dmbps", rate.Mbps())
+	case gosnappi.FlowRateChoice.GBPS:
+		return fmt.Sprintf("%dgbps", rate.Gbps())
+	case gosnappi.FlowRateChoice.PPS:
+		return fmt.Sprintf("%dpps", rate.Pps())
+	}
+	return ""
+}
+
+// Split a rate like "50%" or "10mbps" into a value and a unit
+func parseRate(s string) (float64, string, error) {
+	for _, unit := range []string{"%", "pps", "kbps", "mbps", "gbps", "bps"} {
+		if strings.HasSuffix(strings.ToLower(s), unit) {
+			v, err := strconv.ParseFloat(s[:len(s)-len(unit)], 64)
+			if err != nil || v <= 0 {
+				return 0, "", fmt.Errorf("incorrect rate %s: has to be a positive number followed by a unit", s)
+			}
+			if unit == "%" && v > 100 {
+				return 0, "", fmt.Errorf("incorrect rate %s: can't exceed 100%%", s)
+			}
+			return v, unit, nil
+		}
+	}
+	return 0, "", fmt.Errorf("incorrect rate %s: supported units are %%, pps, bps, kbps, mbps, gbps", s)
+}
+
+// Update rates of all the flows without affecting their transmit state. Flows without a rate in newRates are set back to
+// their configured rates
+func updateFlowRates(api gosnappi.Api, config gosnappi.Config, newRates map[string]string) {
+	all := map[string]string{}
+	for _, f := range config.Flows().Items() {
+		all[f.Name()] = ""
+	}
+	for n, r := range newRates {
+		all[n] = r
+	}
+	updateFlows(api, config, all)
+}
+
+// Update rates of the flows in newRates without affecting their transmit state. An empty rate stands for the rate
+// of the flow in the configuration
+func updateFlows(api gosnappi.Api, config gosnappi.Config, newRates map[string]string) {
+	cu, err := newFlowsUpdate(config, newRates)
+	if err != nil {
+		log.Fatal(err)
+	}
+	res, err := api.UpdateConfig(cu)
+	checkResponse(api, res, err)
+}
+
+// Create a configuration update with new rates of the flows in newRates
+func newFlowsUpdate(config gosnappi.Config, newRates map[string]string) (gosnappi.ConfigUpdate, error) {
+	cu := gosnappi.NewConfigUpdate()
+	fu := cu.Flows().SetPropertyNames([]gosnappi.FlowsUpdatePropertyNamesEnum{gosnappi.FlowsUpdatePropertyNames.RATE})
+	for _, f := range config.Flows().Items() {
+		r, ok := newRates[f.Name()]
+		if !ok {
+			continue
+		}
+		u, err := f.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if r != "" {
+			if err := setFlowRate(u.Rate(), r); err != nil {
+				return nil, err
+			}
+		}
+		fu.Flows().Append(u)
+	}
+	return cu, nil
+}
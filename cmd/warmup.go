@@ -0,0 +1,153 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"time"
+
+	"github.com/open-traffic-generator/snappi/gosnappi"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var otgWarmupStr string     // How long to transmit all flows at a low rate before the measured run
+var otgWarmup time.Duration // Parsed warm-up duration
+var otgWarmupRate string    // Rate to transmit flows at during warm-up
+
+// Transmit all the flows at a low rate to populate forwarding tables of the DUT, then clear the statistics
+func warmUp(api gosnappi.Api, config gosnappi.Config) (gosnappi.Api, gosnappi.Config) {
+	if otgWarmup == 0 || len(config.Flows().Items()) == 0 {
+		return api, config
+	}
+	log.Infof("Warming up for %s at %s...", otgWarmup, otgWarmupRate)
+	warmupRates := map[string]string{}
+	for _, f := range config.Flows().Items() {
+		warmupRates[f.Name()] = otgWarmupRate
+	}
+	updateFlowRates(api, config, warmupRates)
+
+	ts := gosnappi.NewControlState()
+	ts.Traffic().FlowTransmit().SetState(gosnappi.StateTrafficFlowTransmitState.START)
+	res, err := api.SetControlState(ts)
+	checkResponse(api, res, err)
+	printEvent(api, newEvent(EVENT_WARMUP_STARTED))
+
+	// warm-up metrics are not reported
+	ticker := time.NewTicker(otgPullInterval)
+	defer ticker.Stop()
+	warmupStart := time.Now()
+	for time.Since(warmupStart) < otgWarmup {
+		if timeout > 0 && timeout < time.Since(startTime) {
+			log.Errorf("Exceeded maximum time limit, terminating at warmUp after %s", time.Since(startTime))
+			printTimeoutEvent(api, "warmUp")
+			otgExit(1)
+		}
+		<-ticker.C
+	}
+
+	ts.Traffic().FlowTransmit().SetState(gosnappi.StateTrafficFlowTransmitState.STOP)
+	res, err = api.SetControlState(ts)
+	checkResponse(api, res, err)
+	updateFlowRates(api, config, nil)
+	clearStatistics(api)
+	log.Info("warm-up complete, statistics cleared.")
+	printEvent(api, newEvent(EVENT_WARMUP_DONE))
+	return api, config
+}
+
+// Clear port and flow statistics. OTG API has no control to clear them, so the counters are
+// remembered as a baseline, to subtract from all port and flow metrics received afterwards
+func clearStatistics(api gosnappi.Api) {
+	a, ok := api.(*otgApi)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	a.baseline = nil // counters have to be read as they are on the endpoint
+	a.mu.Unlock()
+	portReq := gosnappi.NewMetricsRequest()
+	portReq.Port()
+	flowReq := gosnappi.NewMetricsRequest()
+	flowReq.Flow()
+
+	baseline := map[string]uint64{}
+	for _, req := range []gosnappi.MetricsRequest{portReq, flowReq} {
+		mr, err := a.GetMetrics(req)
+		checkResponse(api, mr, err)
+		rangeCounters(mr, func(key string, v uint64) uint64 {
+			baseline[key] = v
+			return v
+		})
+	}
+	a.mu.Lock()
+	a.baseline = baseline
+	a.mu.Unlock()
+}
+
+// Subtract the baseline from port and flow counters. A counter below its baseline was cleared by the
+// traffic generator itself, and is reported as is
+func (a *otgApi) subtractBaseline(mr gosnappi.MetricsResponse) gosnappi.MetricsResponse {
+	a.mu.Lock()
+	baseline := a.baseline
+	a.mu.Unlock()
+	if mr == nil || baseline == nil {
+		return mr
+	}
+	return rangeCounters(mr, func(key string, v uint64) uint64 {
+		if b, ok := baseline[key]; ok && v >= b {
+			return v - b
+		}
+		return v
+	})
+}
+
+// Call f for every counter of port and flow metrics in the MetricsResponse, identified by a key like
+// "port_metrics/p1/frames_tx", and return a copy of the MetricsResponse with counters set to what f returned
+func rangeCounters(mr gosnappi.MetricsResponse, f func(key string, v uint64) uint64) gosnappi.MetricsResponse {
+	msg, err := mr.Marshal().ToProto()
+	if err != nil {
+		log.Fatal(err)
+	}
+	msg.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		choice := string(fd.Name())
+		if choice != "port_metrics" && choice != "flow_metrics" {
+			return true
+		}
+		list := v.List()
+		for i := 0; i < list.Len(); i++ {
+			item := list.Get(i).Message()
+			name := item.Get(item.Descriptor().Fields().ByName("name")).String()
+			fields := item.Descriptor().Fields()
+			for j := 0; j < fields.Len(); j++ {
+				cfd := fields.Get(j)
+				if cfd.Kind() == protoreflect.Uint64Kind && !cfd.IsList() && item.Has(cfd) {
+					item.Set(cfd, protoreflect.ValueOfUint64(f(choice+"/"+name+"/"+string(cfd.Name()), item.Get(cfd).Uint())))
+				}
+			}
+		}
+		return true
+	})
+	res := gosnappi.NewMetricsResponse()
+	if _, err := res.Unmarshal().FromProto(msg); err != nil {
+		log.Fatal(err)
+	}
+	return res
+}
@@ -0,0 +1,160 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/open-traffic-generator/snappi/gosnappi"
+)
+
+const (
+	WATCH_SETTLE_TIME = 200 * time.Millisecond // Time for an editor to finish writing the file before it is read
+)
+
+var otgWatch bool               // Re-run when the configuration file changes
+var otgConfigWatch *configWatch // Watch of the configuration file, nil if not requested
+
+// configWatch tells when a file was modified, from file system notifications. The directory of the file is watched,
+// rather than the file itself, so that editors saving the file by renaming a new one over it are noticed as well.
+// A change stays pending until the file is read again
+type configWatch struct {
+	file    string
+	watcher *fsnotify.Watcher
+	events  chan struct{} // Signalled on every change of the file
+	mu      sync.Mutex
+	pending bool
+}
+
+func newConfigWatch(file string) *configWatch {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		log.Fatalf("Can't watch %s: %s", file, err)
+	}
+	w := &configWatch{file: file, watcher: watcher, events: make(chan struct{}, 1)}
+	go w.watch()
+	return w
+}
+
+// Receive notifications for the directory, and keep the ones about the file
+func (w *configWatch) watch() {
+	name := filepath.Base(w.file)
+	for {
+		select {
+		case e, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Base(e.Name) != name || !e.Has(fsnotify.Write) && !e.Has(fsnotify.Create) {
+				continue
+			}
+			w.mu.Lock()
+			w.pending = true
+			w.mu.Unlock()
+			select {
+			case w.events <- struct{}{}:
+			default:
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warnf("Watching %s: %s", w.file, err)
+		}
+	}
+}
+
+// Check if the file was modified since it was last read
+func (w *configWatch) changed() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pending
+}
+
+// Wait for the file to be modified
+func (w *configWatch) wait() {
+	for !w.changed() {
+		<-w.events
+	}
+}
+
+// Read and parse the modified file, once it was not modified for WATCH_SETTLE_TIME, as an editor could still be
+// writing it. Returns nil if the configuration is incorrect, so that the run waits for it to be fixed
+func (w *configWatch) read() gosnappi.Config {
+	for settled := false; !settled; {
+		select {
+		case <-w.events:
+		case <-time.After(WATCH_SETTLE_TIME):
+			settled = true
+		}
+	}
+	w.mu.Lock()
+	w.pending = false
+	w.mu.Unlock()
+	otgbytes, err := os.ReadFile(w.file)
+	if err != nil {
+		log.Errorf("Failed to read %s: %s", w.file, err)
+		return nil
+	}
+	config, err := parseOTGConfig(string(otgbytes))
+	if err != nil {
+		log.Errorf("Incorrect OTG configuration in %s: %s", w.file, err)
+		return nil
+	}
+	return config
+}
+
+// Run the configuration, and run it again every time the file changes, until interrupted. Metrics of all the runs
+// are printed to the same output, so the pipeline it goes to keeps running
+func watchConfig(api gosnappi.Api, config gosnappi.Config) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		log.Info("Interrupted, stopping...")
+		otgExit(0) // stop traffic and protocols started so far
+	}()
+
+	for {
+		if config != nil {
+			stopProtocols(runIterations(warmUp(startProtocols(applyConfig(api, config)))))
+		}
+		if !otgConfigWatch.changed() {
+			log.Infof("Waiting for %s to change...", otgConfigWatch.file)
+			otgConfigWatch.wait()
+		}
+		log.Infof("%s changed, running it again", otgConfigWatch.file)
+		config = otgConfigWatch.read()
+	}
+}
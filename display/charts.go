@@ -22,9 +22,28 @@ import (
 	"github.com/lucasb-eyer/go-colorful"
 )
 
+// Number of data points each chart keeps
+const CHART_MAX_POINTS int = 100
+
+// Name of the series used to draw event markers on each chart
+const MARKER_SERIES string = "events"
+
 type ChartProcessor struct {
-	cnt    *container.Container
-	series map[string]*ChartSeries
+	cnt     *container.Container
+	series  map[string]*ChartSeries
+	charts  map[string]*linechart.LineChart
+	markers map[string]*chartMarkers
+	events  int // events received since the last data points were processed
+	samples int // data points processed so far
+}
+
+// chartMarkers are event markers of a chart, drawn across the chart with the sample an event was received at on top.
+// A line chart only draws lines between neighbouring samples, so a marker rises from the sample before and falls back
+// at the sample after. Markers of neighbouring samples alternate between two series, not to overwrite one another
+type chartMarkers struct {
+	series  [2][]float64
+	falling int     // series of a marker to fall back at the next sample, -1 if none
+	min     float64 // bottom of the chart where it falls back to
 }
 
 type ChartSeries struct {
@@ -58,20 +77,26 @@ func (cp *ChartProcessor) Process(data []DataPoint) error {
 		for k, v := range p {
 			if k != NAME_FIELD {
 				series_key := fmt.Sprintf("%s.%s", k, p[NAME_FIELD])
+				series, ok := cp.series[series_key]
+				if !ok { // fields and names not in the first data points have no chart
+					continue
+				}
 				var err error
 				switch val := v.(type) {
 				case float64:
-					err = cp.series[series_key].AddPoint(val)
+					err = series.AddPoint(val)
 				case int:
-					err = cp.series[series_key].AddPoint(float64(val))
+					err = series.AddPoint(float64(val))
 				case string:
+					// values that are not reported, like latency of a flow without latency metrics, or are not
+					// numbers, like session states, leave a gap
 					value, perr := strconv.ParseFloat(val, 64)
 					if perr != nil {
-						return perr
+						value = math.NaN()
 					}
-					err = cp.series[series_key].AddPoint(value)
+					err = series.AddPoint(value)
 				default:
-					err = cp.series[series_key].AddPoint(math.NaN())
+					err = series.AddPoint(math.NaN())
 				}
 				if err != nil {
 					return err
@@ -79,9 +104,73 @@ func (cp *ChartProcessor) Process(data []DataPoint) error {
 			}
 		}
 	}
+	return cp.addMarkers()
+}
+
+func (cp *ChartProcessor) Event(e DataEvent) error {
+	// markers are drawn together with the next data points, as charts may not exist yet
+	cp.events++
 	return nil
 }
 
+// Extend marker series of every chart, with a marker on top at the latest sample if there were new events
+func (cp *ChartProcessor) addMarkers() error {
+	for k, ch := range cp.charts {
+		m, ok := cp.markers[k]
+		if !ok {
+			m = &chartMarkers{falling: -1}
+			cp.markers[k] = m
+		}
+		for i := range m.series {
+			if len(m.series[i]) == CHART_MAX_POINTS {
+				m.series[i] = m.series[i][1:]
+			}
+			m.series[i] = append(m.series[i], math.NaN())
+		}
+		last := len(m.series[0]) - 1
+		if m.falling >= 0 {
+			m.series[m.falling][last] = m.min
+			m.falling = -1
+		}
+		if cp.events > 0 {
+			s := cp.samples % 2
+			min, max := cp.chartRange(ch)
+			if last > 0 {
+				m.series[s][last-1] = min
+			}
+			m.series[s][last] = max
+			m.falling, m.min = s, min
+		}
+		for i, values := range m.series {
+			err := ch.Series(fmt.Sprintf("%s %d", MARKER_SERIES, i), values, linechart.SeriesCellOpts(cell.FgColor(cell.ColorWhite)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cp.samples++
+	cp.events = 0
+	return nil
+}
+
+// Minimum and maximum values across all series of the chart
+func (cp *ChartProcessor) chartRange(ch *linechart.LineChart) (float64, float64) {
+	min, max := 0.0, 0.0
+	for _, s := range cp.series {
+		if s.Chart != ch {
+			continue
+		}
+		for _, v := range s.Data {
+			if math.IsNaN(v) {
+				continue
+			}
+			min = math.Min(min, v)
+			max = math.Max(max, v)
+		}
+	}
+	return min, max
+}
+
 func (cp *ChartProcessor) Layout(data []DataPoint) error {
 	// Looks ugly, but in 10 minutes a better idea didn't come up
 	// So, I shall live with this abomination of the code for the moment.
@@ -94,7 +183,7 @@ func (cp *ChartProcessor) Layout(data []DataPoint) error {
 				if _, ok := cp.series[series_key]; !ok {
 					if _, ok := charts[k]; !ok {
 						ch, _ := linechart.New()
-						charts[k] = NewSeries(k, 100, ch, cell.ColorDefault)
+						charts[k] = NewSeries(k, CHART_MAX_POINTS, ch, cell.ColorDefault)
 					}
 					color := colorful.HappyColor()
 
@@ -110,8 +199,11 @@ func (cp *ChartProcessor) Layout(data []DataPoint) error {
 		}
 	}
 
+	cp.charts = map[string]*linechart.LineChart{}
+	cp.markers = map[string]*chartMarkers{}
 	builder := grid.New()
 	for k, v := range charts {
+		cp.charts[k] = v.Chart
 		builder.Add(grid.RowHeightPerc(99/len(charts), grid.Widget(v.Chart, container.Border(linestyle.Light), container.BorderTitle(k))))
 	}
 	co, err := builder.Build()
@@ -134,8 +226,10 @@ func ChartsFn(cmd *cobra.Command, args []string) error {
 	}
 
 	cp := &ChartProcessor{
-		cnt:    c,
-		series: map[string]*ChartSeries{},
+		cnt:     c,
+		series:  map[string]*ChartSeries{},
+		charts:  map[string]*linechart.LineChart{},
+		markers: map[string]*chartMarkers{},
 	}
 
 	DataProcessorStart(cp)
@@ -0,0 +1,360 @@
+package display
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucasb-eyer/go-colorful"
+	"github.com/mum4k/termdash"
+	"github.com/mum4k/termdash/cell"
+	"github.com/mum4k/termdash/container"
+	"github.com/mum4k/termdash/container/grid"
+	"github.com/mum4k/termdash/keyboard"
+	"github.com/mum4k/termdash/linestyle"
+	"github.com/mum4k/termdash/terminal/tcell"
+	"github.com/mum4k/termdash/terminal/terminalapi"
+	"github.com/mum4k/termdash/widgets/linechart"
+	"github.com/mum4k/termdash/widgets/text"
+	"github.com/olekukonko/tablewriter"
+)
+
+// Keys of the control panel
+const PANEL_HELP string = "↑/↓ select flow | s start flow | x stop flow | a start all | z stop all | p start protocols | o stop protocols | r set rate | q quit"
+
+// PanelController performs actions the operator requests on the control panel
+type PanelController interface {
+	StartFlows(names []string) error // Start transmitting the flows, all of them if names is empty
+	StopFlows(names []string) error  // Stop transmitting the flows, all of them if names is empty
+	StartProtocols() error
+	StopProtocols() error
+	SetFlowRate(name string, rate string) error // Change the rate of a flow, like "50%" or "1000pps"
+}
+
+// PanelState is what the control panel shows, refreshed on every poll
+type PanelState struct {
+	Ports   []DataPoint // Ports with their location and counters
+	Devices []string    // Device descriptions
+	Flows   []DataPoint // Flows with their state, rate and counters
+	Bgp     []DataPoint // BGP sessions with their state and counters
+	Err     error       // Error of the last poll, if any
+}
+
+// Panel is an interactive control panel: a configuration overview with a selectable list of flows, a chart of flow
+// receive rates, a table of metrics and a status line
+type Panel struct {
+	ctrl     PanelController
+	rateKey  string // Field of flow DataPoints to chart
+	mu       sync.Mutex
+	state    PanelState
+	selected int
+	editing  bool   // the operator is typing a new rate for the selected flow
+	input    string // rate typed so far
+	busy     string // action in progress, if any, other actions are rejected until it is done
+	status   string
+	config   *text.Text
+	metrics  *text.Text
+	statusW  *text.Text
+	chart    *linechart.LineChart
+	series   map[string]*ChartSeries
+}
+
+func NewPanel(ctrl PanelController, rateKey string) (*Panel, error) {
+	p := &Panel{ctrl: ctrl, rateKey: rateKey, series: map[string]*ChartSeries{}, status: "ready"}
+	var err error
+	if p.config, err = text.New(text.WrapAtRunes()); err != nil {
+		return nil, err
+	}
+	if p.metrics, err = text.New(); err != nil {
+		return nil, err
+	}
+	if p.statusW, err = text.New(text.WrapAtWords()); err != nil {
+		return nil, err
+	}
+	if p.chart, err = linechart.New(linechart.YAxisAdaptive()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Show the latest state of the configuration and its metrics
+func (p *Panel) Update(s PanelState) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = s
+	if p.selected >= len(s.Flows) {
+		p.selected = 0
+	}
+	if s.Err != nil {
+		p.status = s.Err.Error()
+	}
+	for _, f := range s.Flows {
+		name := fmt.Sprintf("%v", f[NAME_FIELD])
+		if _, ok := p.series[name]; !ok {
+			c := colorful.HappyColor()
+			p.series[name] = NewSeries(name, CHART_MAX_POINTS, p.chart, cell.ColorRGB24(int(255.0*c.R), int(255.0*c.G), int(255.0*c.B)))
+		}
+		v, ok := f[p.rateKey].(float64)
+		if !ok {
+			v = 0
+		}
+		if err := p.series[name].AddPoint(v); err != nil {
+			return err
+		}
+	}
+	return p.redraw()
+}
+
+func (p *Panel) redraw() error {
+	var b strings.Builder
+	fmt.Fprintln(&b, "Ports:")
+	for _, port := range p.state.Ports {
+		fmt.Fprintf(&b, "  %v  %v\n", port[NAME_FIELD], port["location"])
+	}
+	if len(p.state.Devices) > 0 {
+		fmt.Fprintln(&b, "Devices:")
+		for _, d := range p.state.Devices {
+			fmt.Fprintf(&b, "  %s\n", d)
+		}
+	}
+	fmt.Fprintln(&b, "Flows:")
+	for i, f := range p.state.Flows {
+		cursor := " "
+		if i == p.selected {
+			cursor = ">"
+		}
+		fmt.Fprintf(&b, "%s %v  %v  %v\n", cursor, f[NAME_FIELD], f["transmit"], f["rate"])
+	}
+	if len(p.state.Bgp) > 0 {
+		fmt.Fprintln(&b, "BGP sessions:")
+		for _, s := range p.state.Bgp {
+			fmt.Fprintf(&b, "  %v  %v\n", s[NAME_FIELD], s["session_state"])
+		}
+	}
+	if err := p.config.Write(b.String(), text.WriteReplace()); err != nil {
+		return err
+	}
+
+	var m strings.Builder
+	for _, rows := range []struct {
+		title string
+		data  []DataPoint
+	}{{"Flows", p.state.Flows}, {"Ports", p.state.Ports}, {"BGP sessions", p.state.Bgp}} {
+		if len(rows.data) > 0 {
+			fmt.Fprintln(&m, rows.title)
+			m.WriteString(formatTable(rows.data))
+		}
+	}
+	if err := p.metrics.Write(m.String(), text.WriteReplace()); err != nil {
+		return err
+	}
+
+	status := p.status
+	if p.editing {
+		status = fmt.Sprintf("New rate for %s: %s_  (Enter to apply, Esc to cancel)", p.selectedFlow(), p.input)
+	}
+	return p.statusW.Write(status+"\n"+PANEL_HELP, text.WriteReplace())
+}
+
+// Format data points as a table, with columns in alphabetical order after the name
+func formatTable(data []DataPoint) string {
+	columns := map[string]bool{}
+	for _, d := range data {
+		for k := range d {
+			if k != NAME_FIELD {
+				columns[k] = true
+			}
+		}
+	}
+	headers := []string{}
+	for k := range columns {
+		headers = append(headers, k)
+	}
+	sort.Strings(headers)
+	headers = append([]string{NAME_FIELD}, headers...)
+
+	var b strings.Builder
+	table := tablewriter.NewWriter(&b)
+	table.SetHeader(headers)
+	for _, d := range data {
+		line := make([]string, len(headers))
+		for i, h := range headers {
+			if v, ok := d[h]; ok {
+				line[i] = fmt.Sprintf("%v", v)
+			}
+		}
+		table.Append(line)
+	}
+	table.Render()
+	return b.String()
+}
+
+func (p *Panel) selectedFlow() string {
+	if p.selected < len(p.state.Flows) {
+		return fmt.Sprintf("%v", p.state.Flows[p.selected][NAME_FIELD])
+	}
+	return ""
+}
+
+// Handle a key pressed by the operator. Returns false once the operator asked to quit
+func (p *Panel) key(k keyboard.Key) bool {
+	p.mu.Lock()
+	if p.editing {
+		switch k {
+		case keyboard.KeyEnter:
+			p.editing = false
+			flow, rate := p.selectedFlow(), p.input
+			p.start(fmt.Sprintf("rate of %s set to %s", flow, rate), func() error { return p.ctrl.SetFlowRate(flow, rate) })
+			p.redraw()
+			p.mu.Unlock()
+			return true
+		case keyboard.KeyEsc:
+			p.editing = false
+		case keyboard.KeyBackspace, keyboard.KeyBackspace2:
+			if len(p.input) > 0 {
+				p.input = p.input[:len(p.input)-1]
+			}
+		default:
+			if k > 0 && k < 0x7f {
+				p.input += string(rune(k))
+			}
+		}
+		p.redraw()
+		p.mu.Unlock()
+		return true
+	}
+
+	flow := p.selectedFlow()
+	var action func() error
+	var done string
+	switch k {
+	case 'q', keyboard.KeyEsc, keyboard.KeyCtrlC:
+		p.mu.Unlock()
+		return false
+	case keyboard.KeyArrowUp:
+		if p.selected > 0 {
+			p.selected--
+		}
+	case keyboard.KeyArrowDown:
+		if p.selected < len(p.state.Flows)-1 {
+			p.selected++
+		}
+	case 'r':
+		if flow != "" {
+			p.editing, p.input = true, ""
+		}
+	case 's':
+		if flow != "" {
+			action, done = func() error { return p.ctrl.StartFlows([]string{flow}) }, "started "+flow
+		}
+	case 'x':
+		if flow != "" {
+			action, done = func() error { return p.ctrl.StopFlows([]string{flow}) }, "stopped "+flow
+		}
+	case 'a':
+		action, done = func() error { return p.ctrl.StartFlows(nil) }, "started all flows"
+	case 'z':
+		action, done = func() error { return p.ctrl.StopFlows(nil) }, "stopped all flows"
+	case 'p':
+		action, done = p.ctrl.StartProtocols, "started protocols"
+	case 'o':
+		action, done = p.ctrl.StopProtocols, "stopped protocols"
+	}
+	if action != nil {
+		p.start(done, action)
+	}
+	p.redraw()
+	p.mu.Unlock()
+	return true
+}
+
+// Start an action on the controller in the background, to keep the panel responsive while the API call is in
+// progress. Rejected while another action is in progress. Called with p.mu held
+func (p *Panel) start(done string, action func() error) {
+	if p.busy != "" {
+		p.status = "busy, wait for " + p.busy + " to complete"
+		return
+	}
+	p.busy = done
+	go p.do(done, action)
+}
+
+// Perform an action on the controller, and report the outcome in the status line
+func (p *Panel) do(done string, action func() error) {
+	err := action()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy = ""
+	if err != nil {
+		p.status = "error: " + err.Error()
+	} else {
+		p.status = time.Now().Format("15:04:05 ") + done
+	}
+	p.redraw()
+}
+
+// Run the control panel until the operator quits. poll is called every interval to get the state to show
+func (p *Panel) Run(interval time.Duration, poll func() PanelState) error {
+	t, err := tcell.New()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	builder := grid.New()
+	builder.Add(
+		grid.RowHeightPerc(55,
+			grid.ColWidthPerc(35, grid.Widget(p.config, container.Border(linestyle.Light), container.BorderTitle("Configuration"))),
+			grid.ColWidthPerc(65, grid.Widget(p.chart, container.Border(linestyle.Light), container.BorderTitle("Flows "+p.rateKey))),
+		),
+		grid.RowHeightPerc(33, grid.Widget(p.metrics, container.Border(linestyle.Light), container.BorderTitle("Metrics"))),
+		grid.RowHeightPerc(11, grid.Widget(p.statusW, container.Border(linestyle.Light), container.BorderTitle("Status"))),
+	)
+	opts, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	c, err := container.New(t, opts...)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := p.Update(poll()); err != nil {
+				errs <- err
+				cancel()
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	keys := func(k *terminalapi.Keyboard) {
+		if !p.key(k.Key) {
+			cancel()
+		}
+	}
+	const redrawInterval = 250 * time.Millisecond
+	if err := termdash.Run(ctx, t, c, termdash.KeyboardSubscriber(keys), termdash.RedrawInterval(redrawInterval)); err != nil {
+		return err
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
@@ -13,6 +13,7 @@ import (
 type TableProcessor struct {
 	headers  []string
 	data     map[string]map[string]string
+	events   []string
 	terminal *uilive.Writer
 }
 
@@ -56,6 +57,11 @@ func (tp *TableProcessor) Process(input []DataPoint) error {
 	return nil
 }
 
+func (tp *TableProcessor) Event(e DataEvent) error {
+	tp.events = append(tp.events, e.String())
+	return nil
+}
+
 func (tp *TableProcessor) Format() string {
 	tableString := &strings.Builder{}
 	table := tablewriter.NewWriter(tableString)
@@ -70,6 +76,9 @@ func (tp *TableProcessor) Format() string {
 	}
 
 	table.Render()
+	for _, e := range tp.events {
+		fmt.Fprintf(tableString, "event: %s\n", e)
+	}
 	return tableString.String()
 }
 
@@ -77,6 +86,7 @@ func TableFn(cmd *cobra.Command, args []string) error {
 	tp := &TableProcessor{
 		headers:  []string{},
 		data:     map[string]map[string]string{},
+		events:   []string{},
 		terminal: uilive.New(),
 	}
 	tp.terminal.RefreshInterval = time.Microsecond * 250
@@ -3,7 +3,9 @@ package display
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
 	"os"
+	"sort"
 	"sync"
 
 	log "github.com/sirupsen/logrus"
@@ -12,11 +14,17 @@ import (
 // TODO: would be nice to make it configurable or automatically determined
 const NAME_FIELD string = "name"
 
+// Event records from "otgen run --events" are JSON objects with this field
+const EVENT_FIELD string = "event"
+
 type DataPoint map[string]interface{}
 
+type DataEvent map[string]interface{}
+
 type DataProcessor interface {
 	Layout([]DataPoint) error
 	Process([]DataPoint) error
+	Event(DataEvent) error
 }
 
 func DataProcessorStart(dp DataProcessor) *sync.WaitGroup {
@@ -34,7 +42,15 @@ func DataProcessorStart(dp DataProcessor) *sync.WaitGroup {
 			var input []DataPoint
 			err := json.Unmarshal([]byte(text), &input)
 			if err != nil {
-				log.Fatal(err)
+				var event DataEvent
+				if json.Unmarshal([]byte(text), &event) != nil || event[EVENT_FIELD] == nil {
+					log.Fatal(err)
+				}
+				err = dp.Event(event)
+				if err != nil {
+					log.Fatal(err)
+				}
+				continue
 			}
 
 			if needLayout {
@@ -55,3 +71,19 @@ func DataProcessorStart(dp DataProcessor) *sync.WaitGroup {
 
 	return &wg
 }
+
+// Format an event as its name followed by the rest of the fields, except for the timestamp
+func (e DataEvent) String() string {
+	keys := []string{}
+	for k := range e {
+		if k != EVENT_FIELD && k != "timestamp" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	s := fmt.Sprintf("%v", e[EVENT_FIELD])
+	for _, k := range keys {
+		s += fmt.Sprintf(" %s=%v", k, e[k])
+	}
+	return s
+}
@@ -0,0 +1,136 @@
+/*
+Copyright © 2022 Open Traffic Generator
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Stand-in for an OpenTelemetry collector, to test "otgen export otlp" with. Receives metrics over OTLP/gRPC and
+// OTLP/HTTP, and prints resource attributes and sums of every request, skipping requests that print the
+// same as the one before, so that the output doesn't depend on how many times metrics were pushed.
+//
+//	go run ./test/export/otlpcollector 127.0.0.1:4317 127.0.0.1:4318
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+var last string
+var mu sync.Mutex
+
+// Print the request, unless it prints the same as the last one
+func print(req *colmetricspb.ExportMetricsServiceRequest) {
+	var b strings.Builder
+	for _, rm := range req.GetResourceMetrics() {
+		fmt.Fprintf(&b, "resource %s\n", attributes(rm.GetResource().GetAttributes()))
+		sums := []string{}
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				sum := m.GetSum()
+				if sum == nil {
+					continue
+				}
+				for _, dp := range sum.GetDataPoints() {
+					sums = append(sums, fmt.Sprintf("sum %s %s %s monotonic=%t start=%s time=%s value=%d\n", m.GetName(), attributes(dp.GetAttributes()),
+						sum.GetAggregationTemporality(), sum.GetIsMonotonic(), timestamp(dp.GetStartTimeUnixNano()), timestamp(dp.GetTimeUnixNano()), dp.GetAsInt()))
+				}
+			}
+		}
+		sort.Strings(sums)
+		b.WriteString(strings.Join(sums, ""))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if b.String() != last {
+		fmt.Print(b.String())
+		last = b.String()
+	}
+}
+
+// Sorted attributes like {k1=v1,k2=v2}
+func attributes(kvs []*commonpb.KeyValue) string {
+	s := []string{}
+	for _, kv := range kvs {
+		s = append(s, kv.GetKey()+"="+kv.GetValue().GetStringValue())
+	}
+	sort.Strings(s)
+	return "{" + strings.Join(s, ",") + "}"
+}
+
+func timestamp(ns uint64) string {
+	return time.Unix(0, int64(ns)).UTC().Format(time.RFC3339Nano)
+}
+
+type server struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+}
+
+func (server) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	print(req)
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		log.Fatalf("Usage: %s grpc-address http-address", os.Args[0])
+	}
+	l, err := net.Listen("tcp", os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	g := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(g, server{})
+	go func() {
+		log.Fatal(g.Serve(l))
+	}()
+
+	http.HandleFunc("/v1/metrics", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		req := &colmetricspb.ExportMetricsServiceRequest{}
+		if err == nil && r.Header.Get("Content-Type") == "application/x-protobuf" {
+			err = proto.Unmarshal(body, req)
+		} else if err == nil {
+			err = fmt.Errorf("unsupported content type %s", r.Header.Get("Content-Type"))
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		print(req)
+		res, _ := proto.Marshal(&colmetricspb.ExportMetricsServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(res)
+	})
+	log.Fatal(http.ListenAndServe(os.Args[2], nil))
+}